@@ -0,0 +1,155 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// targetView is how a target is shown by the REST API
+type targetView struct {
+	ID           string     `json:"id"`
+	Host         string     `json:"host"`
+	URL          string     `json:"url"`
+	State        string     `json:"state"`
+	Paused       bool       `json:"paused"`
+	AddedAtRun   bool       `json:"addedAtRuntime"`
+	LastCheck    *time.Time `json:"lastCheck,omitempty"`
+	LastResponse float64    `json:"lastResponseSeconds"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+// apiHandler serves the REST API for managing targets at runtime:
+//   GET    /api/targets             lists the targets
+//   POST   /api/targets             adds a target, see targetSpec
+//   DELETE /api/targets/{id}        removes a target
+//   POST   /api/targets/{id}/pause  pauses the monitoring of a target
+//   POST   /api/targets/{id}/resume resumes the monitoring of a target
+//   POST   /api/targets/{id}/check  checks a target now
+// Every request needs an "Authorization: Bearer <apiToken>" header.
+type apiHandler struct {
+	token string
+}
+
+func (a *apiHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(a.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="web-mon"`)
+		writeJSONError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/targets"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "" && req.Method == "GET":
+		a.listTargets(w)
+	case len(parts) == 1 && parts[0] == "" && req.Method == "POST":
+		a.addTarget(w, req)
+	case len(parts) == 1 && req.Method == "DELETE":
+		a.changed(w, parts[0], monitors.stop(parts[0]))
+	case len(parts) == 2 && req.Method == "POST":
+		a.controlTarget(w, parts[0], parts[1])
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown API request: "+req.Method+" "+req.URL.Path)
+	}
+}
+
+func (a *apiHandler) listTargets(w http.ResponseWriter) {
+	views := []targetView{}
+	for _, m := range monitors.list() {
+		view := targetView{
+			ID:         targetID(&m.target),
+			Host:       m.target.host,
+			URL:        m.target.url,
+			State:      stateUnknown.String(),
+			Paused:     m.control.isPaused(),
+			AddedAtRun: m.spec != nil,
+		}
+		if status, ok := dashboard.snapshot(&m.target); ok {
+			view.State = status.state.String()
+			view.LastError = status.lastError
+			view.LastResponse = status.lastResponse.Seconds()
+			if !status.lastCheck.IsZero() {
+				view.LastCheck = &status.lastCheck
+			}
+		}
+		views = append(views, view)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (a *apiHandler) addTarget(w http.ResponseWriter, req *http.Request) {
+	var spec targetSpec
+	if err := json.NewDecoder(req.Body).Decode(&spec); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid target: "+err.Error())
+		return
+	}
+	target, err := spec.toTarget()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid target: "+err.Error())
+		return
+	}
+	if err := monitors.start(target, &spec); err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("Added %s: %s through the API\n", target.host, target.url)
+	a.saveState()
+	writeJSON(w, http.StatusCreated, map[string]string{"id": targetID(&target)})
+}
+
+func (a *apiHandler) controlTarget(w http.ResponseWriter, id, action string) {
+	control, err := monitors.control(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	switch action {
+	case "pause":
+		control.setPaused(true)
+		a.changed(w, id, nil)
+	case "resume":
+		control.setPaused(false)
+		a.changed(w, id, nil)
+	case "check":
+		control.requestCheck()
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": id})
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown action: "+action)
+	}
+}
+
+// changed saves the state after a successful change and responds to the request
+func (a *apiHandler) changed(w http.ResponseWriter, id string, err error) {
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	a.saveState()
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+func (a *apiHandler) saveState() {
+	if err := monitors.saveState(); err != nil {
+		log.Println("Error saving the API state file:", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiRequest sends a request with the right token to the handler
+func apiRequest(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// stopMonitors stops the running monitors and waits for them, so they don't run during the other tests
+func stopMonitors() {
+	for _, m := range monitors.list() {
+		monitors.stop(targetID(&m.target))
+		<-m.control.done
+	}
+}
+
+// stubChecks replaces doGet with one that reports the checked hosts on the returned channel
+func stubChecks() (chan string, func()) {
+	savedGet := doGet
+	checks := make(chan string, 10)
+	doGet = func(target *Target) error {
+		select {
+		case checks <- target.host:
+		default:
+		}
+		return nil
+	}
+	return checks, func() { doGet = savedGet }
+}
+
+func waitForCheck(t *testing.T, checks chan string, host string) {
+	select {
+	case checked := <-checks:
+		if checked != host {
+			t.Errorf("expected %s to be checked, got %s", host, checked)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %s to be checked", host)
+	}
+}
+
+func Test_apiRequiresToken(t *testing.T) {
+	monitors = newSupervisor(make(chan *Target, 10), "")
+	handler := &apiHandler{token: "s3cret"}
+	for _, auth := range []string{"", "Bearer", "Bearer wrong", "Basic s3cret", "bearer s3cret"} {
+		req := httptest.NewRequest("GET", "/api/targets", nil)
+		if len(auth) > 0 {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized || len(w.Header().Get("WWW-Authenticate")) == 0 {
+			t.Errorf("expected %q to be rejected, got %d", auth, w.Code)
+		}
+	}
+	if w := apiRequest(handler, "GET", "/api/targets", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the token to be accepted, got %d", w.Code)
+	}
+}
+
+func Test_apiTargets(t *testing.T) {
+	checks, restore := stubChecks()
+	defer restore()
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+	monitors = newSupervisor(make(chan *Target, 10), stateFile)
+	defer stopMonitors()
+	handler := &apiHandler{token: "s3cret"}
+
+	w := apiRequest(handler, "POST", "/api/targets", `{"host": "api", "url": "https://api.example.com/ping"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the target to be added, got %d %s", w.Code, w.Body)
+	}
+	var added map[string]string
+	json.Unmarshal(w.Body.Bytes(), &added)
+	id := added["id"]
+	waitForCheck(t, checks, "api")
+
+	if w := apiRequest(handler, "POST", "/api/targets", `{"host": "api", "url": "https://api.example.com/ping"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected a duplicate target to be rejected, got %d", w.Code)
+	}
+	if w := apiRequest(handler, "POST", "/api/targets", `{"host": "api", "url": "ftp://api.example.com"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid target to be rejected, got %d", w.Code)
+	}
+
+	// A paused target is still checked when asked to
+	if w := apiRequest(handler, "POST", "/api/targets/"+id+"/pause", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the target to be paused, got %d", w.Code)
+	}
+	var views []targetView
+	json.Unmarshal(apiRequest(handler, "GET", "/api/targets", "").Body.Bytes(), &views)
+	if len(views) != 1 || views[0].ID != id || !views[0].Paused || !views[0].AddedAtRun {
+		t.Errorf("expected the paused target to be listed, got %+v", views)
+	}
+	if w := apiRequest(handler, "POST", "/api/targets/"+id+"/check", ""); w.Code != http.StatusAccepted {
+		t.Errorf("expected a check to be requested, got %d", w.Code)
+	}
+	waitForCheck(t, checks, "api")
+	if w := apiRequest(handler, "POST", "/api/targets/"+id+"/resume", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the target to be resumed, got %d", w.Code)
+	}
+	if w := apiRequest(handler, "POST", "/api/targets/"+id+"/restart", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown action to be rejected, got %d", w.Code)
+	}
+
+	if w := apiRequest(handler, "DELETE", "/api/targets/"+id, ""); w.Code != http.StatusOK {
+		t.Errorf("expected the target to be removed, got %d", w.Code)
+	}
+	if w := apiRequest(handler, "DELETE", "/api/targets/"+id, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown target, got %d", w.Code)
+	}
+	if len(monitors.list()) != 0 {
+		t.Errorf("expected no targets, got %d", len(monitors.list()))
+	}
+}
+
+func Test_apiStateFile(t *testing.T) {
+	checks, restore := stubChecks()
+	defer restore()
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+	configured := []Target{
+		{host: "one", url: "https://one.example.com/ping"},
+		{host: "two", url: "https://two.example.com/ping"},
+	}
+
+	// Remove a configured target, pause the other one, and add one
+	monitors = newSupervisor(make(chan *Target, 10), stateFile)
+	for _, target := range configured {
+		monitors.start(target, nil)
+	}
+	handler := &apiHandler{token: "s3cret"}
+	apiRequest(handler, "DELETE", "/api/targets/"+targetID(&configured[0]), "")
+	apiRequest(handler, "POST", "/api/targets/"+targetID(&configured[1])+"/pause", "")
+	apiRequest(handler, "POST", "/api/targets", `{"host": "api", "url": "https://api.example.com/ping", "options": {"method": "HEAD"}}`)
+	stopMonitors()
+
+	// After a restart, the changes are applied to the configured targets
+	for len(checks) > 0 {
+		<-checks
+	}
+	monitors = newSupervisor(make(chan *Target, 10), stateFile)
+	defer stopMonitors()
+	remaining, err := monitors.loadState(configured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].host != "two" {
+		t.Fatalf("expected only target two to remain, got %v", remaining)
+	}
+	waitForCheck(t, checks, "api")
+	monitors.start(remaining[0], nil)
+
+	list := monitors.list()
+	if len(list) != 2 || list[0].target.host != "api" || list[1].target.host != "two" {
+		t.Fatalf("expected targets api and two, got %d targets", len(list))
+	}
+	if list[0].spec == nil || list[0].target.method != "HEAD" {
+		t.Error("expected the added target to be restored with its options")
+	}
+	if list[0].control.isPaused() || !list[1].control.isPaused() {
+		t.Error("expected only target two to be paused")
+	}
+}
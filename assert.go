@@ -0,0 +1,87 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Assertion is a check made against the body of an HTTP response.
+// They are configured per target like this:
+//   monitor.target1.assert1 = contains Welcome
+//   monitor.target1.assert2 = notContains Down for maintenance
+//   monitor.target1.assert3 = matches "status"\s*:\s*"ok"
+//   monitor.target1.assert4 = json db.status equals UP
+type Assertion interface {
+	// Check returns an *AssertionError when the body does not satisfy the assertion
+	Check(body []byte) error
+	String() string
+}
+
+// AssertionError is returned by doGet when a response body fails an assertion
+type AssertionError struct {
+	Assertion Assertion
+	Detail    string
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("assertion %s failed: %s", e.Assertion, e.Detail)
+}
+
+// bodyAssertion checks for text (or a regular expression) in the response body
+type bodyAssertion struct {
+	name    string // config name, e.g. assert1
+	kind    string // contains, notContains, or matches
+	text    string
+	pattern *regexp.Regexp
+}
+
+func (a *bodyAssertion) Check(body []byte) error {
+	switch a.kind {
+	case "contains":
+		if !bytes.Contains(body, []byte(a.text)) {
+			return &AssertionError{Assertion: a, Detail: "text not found in response body"}
+		}
+	case "notContains":
+		if bytes.Contains(body, []byte(a.text)) {
+			return &AssertionError{Assertion: a, Detail: "text found in response body"}
+		}
+	case "matches":
+		if !a.pattern.Match(body) {
+			return &AssertionError{Assertion: a, Detail: "no match in response body"}
+		}
+	}
+	return nil
+}
+
+func (a *bodyAssertion) String() string {
+	return fmt.Sprintf("%s (%s %q)", a.name, a.kind, a.text)
+}
+
+// parseAssertion converts a config value like "contains Welcome" into an Assertion
+func parseAssertion(name, value string) (Assertion, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), " ", 2)
+	if len(parts) < 2 || len(strings.TrimSpace(parts[1])) == 0 {
+		return nil, fmt.Errorf("assertion must have a type and a value: %s", value)
+	}
+	kind, text := parts[0], strings.TrimSpace(parts[1])
+	switch kind {
+	case "contains", "notContains":
+		return &bodyAssertion{name: name, kind: kind, text: text}, nil
+	case "matches":
+		pattern, err := regexp.Compile(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %s", text, err)
+		}
+		return &bodyAssertion{name: name, kind: kind, text: text, pattern: pattern}, nil
+	case "json":
+		return parseJSONAssertion(name, text)
+	}
+	return nil, fmt.Errorf("unknown assertion type %q (expected contains, notContains, matches, or json)", kind)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var healthJSON = []byte(`{"status":"UP","db":{"status":"DOWN","latency":12.5},"checks":[{"name":"disk","free":300}]}`)
+
+func Test_bodyAssertions(t *testing.T) {
+	body := []byte("<html><title>Welcome</title></html>")
+
+	tests := []struct {
+		value string
+		pass  bool
+	}{
+		{"contains Welcome", true},
+		{"contains Goodbye", false},
+		{"notContains Maintenance", true},
+		{"notContains Welcome", false},
+		{"matches <title>\\w+</title>", true},
+		{"matches ^Welcome", false},
+	}
+
+	for _, test := range tests {
+		assertion, err := parseAssertion("assert1", test.value)
+		if err != nil {
+			t.Fatalf("parseAssertion(%q) returned error: %s", test.value, err)
+		}
+		err = assertion.Check(body)
+		if test.pass && err != nil {
+			t.Errorf("%q should pass but failed: %s", test.value, err)
+		}
+		if !test.pass && err == nil {
+			t.Errorf("%q should fail but passed", test.value)
+		}
+	}
+}
+
+func Test_jsonAssertions(t *testing.T) {
+	tests := []struct {
+		value string
+		pass  bool
+	}{
+		{"json status equals UP", true},
+		{"json $.db.status equals UP", false},
+		{"json db.status notEquals UP", true},
+		{"json db.latency lessThan 20", true},
+		{"json db.latency greaterThan 20", false},
+		{"json checks[0].free equals 300", true},
+		{"json checks[0].name exists", true},
+		{"json checks[1].name exists", false},
+	}
+
+	for _, test := range tests {
+		assertion, err := parseAssertion("assert1", test.value)
+		if err != nil {
+			t.Fatalf("parseAssertion(%q) returned error: %s", test.value, err)
+		}
+		err = assertion.Check(healthJSON)
+		if test.pass && err != nil {
+			t.Errorf("%q should pass but failed: %s", test.value, err)
+		}
+		if !test.pass && err == nil {
+			t.Errorf("%q should fail but passed", test.value)
+		}
+	}
+
+	// The alert message should include the actual value
+	assertion, _ := parseAssertion("assert2", "json db.status equals UP")
+	err := assertion.Check(healthJSON)
+	if err == nil || !strings.Contains(err.Error(), "DOWN") || !strings.Contains(err.Error(), "assert2") {
+		t.Errorf("expected error naming assert2 and the actual value DOWN, got: %v", err)
+	}
+}
+
+func Test_invalidAssertions(t *testing.T) {
+	for _, value := range []string{"contains", "startsWith abc", "matches [", "json a.b", "json a.b lessThan abc", "json a..b exists"} {
+		if _, err := parseAssertion("assert1", value); err == nil {
+			t.Errorf("parseAssertion(%q) should have returned an error", value)
+		}
+	}
+}
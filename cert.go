@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CertificateError describes a problem with the certificate chain of an https target
+type CertificateError struct {
+	Problem  string
+	Cert     *x509.Certificate
+	Expiring bool // a warning that the certificate expires soon, the check itself succeeded
+}
+
+func (e *CertificateError) Error() string {
+	if e.Cert == nil {
+		return "certificate problem: " + e.Problem
+	}
+	return fmt.Sprintf("certificate problem: %s (subject: %s, issuer: %s, serial: %X, days remaining: %d)",
+		e.Problem, e.Cert.Subject, e.Cert.Issuer, e.Cert.SerialNumber, certDaysRemaining(e.Cert))
+}
+
+// certDaysRemaining returns the number of whole days until the certificate expires
+func certDaysRemaining(cert *x509.Certificate) int {
+	return int(time.Until(cert.NotAfter).Hours() / 24)
+}
+
+// certificateErrorFrom converts a TLS verification error from the http client into a CertificateError.
+// Other errors are returned unchanged.
+func certificateErrorFrom(err error) error {
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &hostnameErr):
+		return &CertificateError{Problem: "hostname verification failed: " + hostnameErr.Error(), Cert: hostnameErr.Certificate}
+	case errors.As(err, &authorityErr):
+		return &CertificateError{Problem: "untrusted chain: " + authorityErr.Error(), Cert: authorityErr.Cert}
+	case errors.As(err, &invalidErr):
+		return &CertificateError{Problem: "invalid certificate: " + invalidErr.Error(), Cert: invalidErr.Cert}
+	}
+	return err
+}
+
+// earliestExpiring returns the certificate in the chain that expires first
+func earliestExpiring(chain []*x509.Certificate) *x509.Certificate {
+	var earliest *x509.Certificate
+	for _, cert := range chain {
+		if earliest == nil || cert.NotAfter.Before(earliest.NotAfter) {
+			earliest = cert
+		}
+	}
+	return earliest
+}
+
+// checkCertExpiry returns a CertificateError when the target's certificate has crossed
+// one of the certExpiryWarningDays thresholds.  Each threshold is only reported once
+// per certificate, so a renewed certificate starts over.
+func checkCertExpiry(target *Target) error {
+	cert := target.peerCert
+	warningDays := live().certExpiryWarningDays
+	if cert == nil || len(warningDays) == 0 {
+		return nil
+	}
+
+	serial := cert.SerialNumber.String()
+	if serial != target.certSerial {
+		target.certSerial = serial
+		target.certWarnedDays = 0
+	}
+
+	days := certDaysRemaining(cert)
+	threshold := 0
+	for _, d := range warningDays {
+		if days <= d {
+			threshold = d // the list is sorted largest first, so this ends on the smallest one crossed
+		}
+	}
+	if threshold == 0 || (target.certWarnedDays != 0 && threshold >= target.certWarnedDays) {
+		return nil
+	}
+
+	target.certWarnedDays = threshold
+	return &CertificateError{Problem: fmt.Sprintf("expires within %d days on %s", threshold, cert.NotAfter.Format("2006-01-02")), Cert: cert, Expiring: true}
+}
+
+// parseWarningDays converts a value like "30, 14, 7, 1" into thresholds sorted largest first
+func parseWarningDays(value string) ([]int, error) {
+	days := []int{}
+	for _, part := range commaSplittingRegex.Split(value, -1) {
+		if len(part) == 0 {
+			continue
+		}
+		var d int
+		if _, err := fmt.Sscanf(part, "%d", &d); err != nil || d < 1 {
+			return nil, fmt.Errorf("invalid number of days %q", part)
+		}
+		days = append(days, d)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+	return days, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_checkCertExpiry(t *testing.T) {
+	saved := certExpiryWarningDays
+	certExpiryWarningDays = []int{30, 14, 7, 1}
+	publishSettings()
+	defer func() {
+		certExpiryWarningDays = saved
+		publishSettings()
+	}()
+
+	// Each check of the same target, in order
+	tests := []struct {
+		serial   int64 // 0 for no certificate
+		daysLeft int
+		warning  string // the expected warning, empty for none
+	}{
+		{1, 40, ""},
+		{1, 30, "within 30 days"},
+		{1, 29, ""},
+		{1, 13, "within 14 days"},
+		{1, 2, "within 7 days"}, // only the smallest threshold crossed is reported
+		{1, 2, ""},
+		{1, 1, "within 1 days"},
+		{1, 0, ""},
+		{0, 0, ""},
+		{2, 20, "within 30 days"}, // a renewed certificate starts over
+		{2, 20, ""},
+		{2, 10, "within 14 days"},
+	}
+	target := &Target{host: "shop", url: "https://shop.example.com"}
+	for i, test := range tests {
+		target.peerCert = nil
+		if test.serial != 0 {
+			target.peerCert = &x509.Certificate{
+				SerialNumber: big.NewInt(test.serial),
+				NotAfter:     time.Now().Add(time.Duration(test.daysLeft)*24*time.Hour + time.Hour),
+			}
+		}
+		err := checkCertExpiry(target)
+		if len(test.warning) == 0 && err != nil {
+			t.Errorf("check %d: expected no warning, got %s", i+1, err)
+		} else if len(test.warning) > 0 {
+			certErr, ok := err.(*CertificateError)
+			if !ok || !certErr.Expiring || !strings.Contains(certErr.Problem, test.warning) {
+				t.Errorf("check %d: expected a warning %q, got %v", i+1, test.warning, err)
+			}
+		}
+	}
+}
+
+func Test_certWarningSkipsShellCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "ran")
+	script := filepath.Join(dir, "dump-threads.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\ntouch "+marker+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	savedCommand, savedNotifiers, savedMailHost := shellCommand, notifiers, mailHost
+	shellCommand, notifiers, mailHost = script, []Notifier{}, ""
+	defer func() { shellCommand, notifiers, mailHost = savedCommand, savedNotifiers, savedMailHost }()
+
+	target := &Target{host: "shop", url: "https://shop.example.com",
+		err: &CertificateError{Problem: "expires within 7 days on 2030-01-01", Expiring: true}}
+	handleAlert(target)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected the shell command not to run for an expiry warning")
+	}
+
+	// A certificate that fails verification is an alert, which runs it
+	target.err = &CertificateError{Problem: "untrusted chain"}
+	handleAlert(target)
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("expected the shell command to run for an alert")
+	}
+}
@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// severityColors are the colors used for each severity, without the leading #
+var severityColors = map[string]string{
+	severityDown:      "D00000",
+	severitySlow:      "FFA500",
+	severityRecovered: "2EB886",
+	severityWarning:   "ECB22E",
+}
+
+// chatFacts returns the name/value pairs shown in chat messages
+func chatFacts(event *Event) [][2]string {
+	facts := [][2]string{
+		{"Host", event.Host},
+		{"State", event.State},
+		{"Response time", fmt.Sprintf("%v (max %v)", event.ResponseTime.Truncate(time.Millisecond), event.MaxResponse)},
+	}
+	if event.Kind == eventRecovery {
+		facts = append(facts, [2]string{"Outage", event.Outage.Truncate(time.Second).String()})
+	} else {
+		facts = append(facts, [2]string{"Error", event.Error})
+	}
+	return facts
+}
+
+// SlackNotifier posts Block Kit messages to a Slack incoming webhook
+type SlackNotifier struct {
+	url     string
+	channel string // optional, overrides the webhook's default channel
+}
+
+// Name is part of the Notifier interface
+func (s *SlackNotifier) Name() string {
+	return "slack"
+}
+
+// Notify is part of the Notifier interface
+func (s *SlackNotifier) Notify(event *Event) error {
+	fields := []interface{}{}
+	for _, fact := range chatFacts(event) {
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", fact[0], fact[1])})
+	}
+	message := map[string]interface{}{
+		"text": event.Subject, // shown in notifications
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"color": "#" + severityColors[event.Severity],
+				"blocks": []interface{}{
+					map[string]interface{}{
+						"type": "section",
+						"text": map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*%s*", event.Subject)},
+					},
+					map[string]interface{}{"type": "section", "fields": fields},
+					map[string]interface{}{
+						"type": "context",
+						"elements": []interface{}{
+							map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("<%s|%s>", event.URL, event.URL)},
+						},
+					},
+				},
+			},
+		},
+	}
+	if len(s.channel) > 0 {
+		message["channel"] = s.channel
+	}
+	return postJSON(s.Name(), s.url, message)
+}
+
+// TeamsNotifier posts MessageCards to a Microsoft Teams incoming webhook
+type TeamsNotifier struct {
+	url string
+}
+
+// Name is part of the Notifier interface
+func (t *TeamsNotifier) Name() string {
+	return "teams"
+}
+
+// Notify is part of the Notifier interface
+func (t *TeamsNotifier) Notify(event *Event) error {
+	facts := []interface{}{}
+	for _, fact := range chatFacts(event) {
+		facts = append(facts, map[string]string{"name": fact[0], "value": fact[1]})
+	}
+	card := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "http://schema.org/extensions",
+		"themeColor": severityColors[event.Severity],
+		"summary":    event.Subject,
+		"sections": []interface{}{
+			map[string]interface{}{
+				"activityTitle": event.Subject,
+				"facts":         facts,
+			},
+		},
+		"potentialAction": []interface{}{
+			map[string]interface{}{
+				"@type":   "OpenUri",
+				"name":    "Open " + event.Host,
+				"targets": []interface{}{map[string]string{"os": "default", "uri": event.URL}},
+			},
+		},
+	}
+	return postJSON(t.Name(), t.url, card)
+}
+
+// postJSON sends the message to a chat webhook, retrying a couple of times if needed
+func postJSON(name, url string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return postWithRetries(name, 10*time.Second, 2, time.Second, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_chatColors(t *testing.T) {
+	alert, recovery := testEvents()
+	slow := *alert
+	slow.Severity = severitySlow
+	warning := *alert
+	warning.Severity = severityWarning
+	tests := []struct {
+		event *Event
+		color string
+	}{
+		{alert, "D00000"},
+		{&slow, "FFA500"},
+		{recovery, "2EB886"},
+		{&warning, "ECB22E"},
+	}
+
+	standIn := &eventsStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	slack := &SlackNotifier{url: server.URL, channel: "#ops"}
+	teams := &TeamsNotifier{url: server.URL}
+	for i, test := range tests {
+		if err := slack.Notify(test.event); err != nil {
+			t.Fatal(err)
+		}
+		if err := teams.Notify(test.event); err != nil {
+			t.Fatal(err)
+		}
+		attachment := standIn.bodies[2*i]["attachments"].([]interface{})[0].(map[string]interface{})
+		if attachment["color"] != "#"+test.color {
+			t.Errorf("expected the Slack color #%s for %s, got %v", test.color, test.event.Severity, attachment["color"])
+		}
+		if standIn.bodies[2*i+1]["themeColor"] != test.color {
+			t.Errorf("expected the Teams color %s for %s, got %v", test.color, test.event.Severity, standIn.bodies[2*i+1]["themeColor"])
+		}
+	}
+}
+
+func Test_slackMessage(t *testing.T) {
+	standIn := &eventsStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	alert, recovery := testEvents()
+	slack := &SlackNotifier{url: server.URL, channel: "#ops"}
+	if err := slack.Notify(alert); err != nil {
+		t.Fatal(err)
+	}
+	message := standIn.bodies[0]
+	if message["text"] != alert.Subject || message["channel"] != "#ops" {
+		t.Errorf("unexpected text or channel: %v %v", message["text"], message["channel"])
+	}
+	blocks := message["attachments"].([]interface{})[0].(map[string]interface{})["blocks"].([]interface{})
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(blocks))
+	}
+	fields := blocks[1].(map[string]interface{})["fields"].([]interface{})
+	last := fields[len(fields)-1].(map[string]interface{})["text"].(string)
+	if last != "*Error*\n"+alert.Error {
+		t.Errorf("expected the error as the last field, got %q", last)
+	}
+	context := blocks[2].(map[string]interface{})["elements"].([]interface{})[0].(map[string]interface{})
+	if context["text"] != "<"+alert.URL+"|"+alert.URL+">" {
+		t.Errorf("expected a link to the target, got %v", context["text"])
+	}
+
+	// A recovery shows the outage instead of the error, and the default channel is kept
+	slack.channel = ""
+	if err := slack.Notify(recovery); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := standIn.bodies[1]["channel"]; ok {
+		t.Error("expected no channel")
+	}
+	blocks = standIn.bodies[1]["attachments"].([]interface{})[0].(map[string]interface{})["blocks"].([]interface{})
+	fields = blocks[1].(map[string]interface{})["fields"].([]interface{})
+	if last := fields[len(fields)-1].(map[string]interface{})["text"].(string); !strings.HasPrefix(last, "*Outage*\n") {
+		t.Errorf("expected the outage as the last field, got %q", last)
+	}
+}
+
+func Test_teamsCard(t *testing.T) {
+	standIn := &eventsStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	alert, _ := testEvents()
+	teams := &TeamsNotifier{url: server.URL}
+	if err := teams.Notify(alert); err != nil {
+		t.Fatal(err)
+	}
+	card := standIn.bodies[0]
+	if card["@type"] != "MessageCard" || card["summary"] != alert.Subject {
+		t.Errorf("unexpected card: %v", card)
+	}
+	section := card["sections"].([]interface{})[0].(map[string]interface{})
+	facts := section["facts"].([]interface{})
+	if section["activityTitle"] != alert.Subject || len(facts) != 4 {
+		t.Fatalf("expected the subject and 4 facts, got %v", section)
+	}
+	if fact := facts[0].(map[string]interface{}); fact["name"] != "Host" || fact["value"] != alert.Host {
+		t.Errorf("expected the host as the first fact, got %v", fact)
+	}
+	action := card["potentialAction"].([]interface{})[0].(map[string]interface{})
+	uri := action["targets"].([]interface{})[0].(map[string]interface{})["uri"]
+	if action["@type"] != "OpenUri" || uri != alert.URL {
+		t.Errorf("expected an action that opens the target, got %v", action)
+	}
+}
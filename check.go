@@ -0,0 +1,305 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configNames are the names of the settings in a config file, aside from the targets and webhooks
+var configNames = []string{
+	"verbose", "maxResponseTimeInSeconds", "maxDnsTimeInMillis", "maxConnectTimeInMillis", "maxTlsTimeInMillis",
+	"maxTtfbInMillis", "alertPercentile", "alertPercentileThresholdInMillis", "alertPercentileWindowInMinutes",
+	"monitorIntervalInMinutes", "disableIntervalInMinutes", "failuresBeforeAlert", "failureWindow",
+	"retryIntervalInSeconds", "logIntervalInMinutes", "shellCommand", "recoveryShellCommand",
+	"certExpiryWarningDays", "httpListenAddress", "apiToken", "apiStateFile", "configCheckIntervalInSeconds",
+	"historyDirectory", "historyRetentionInDays", "historyRollupRetentionInDays",
+	"mailHost", "mailPort", "mailUsername", "mailPassword", "mailFrom", "mailTo",
+	"slackWebhookUrl", "slackChannel", "teamsWebhookUrl",
+	"pagerDutyRoutingKey", "pagerDutyUrl", "opsgenieApiKey", "opsgenieUrl",
+	"maxResponseTime", "connectTimeout", "monitorInterval", "disableInterval", "retryInterval", "logInterval",
+}
+
+// targetOptionNames are the names of the per-target settings, e.g. monitor.target1.expectedStatus
+var targetOptionNames = []string{
+	"host", "url", "user", "password", "expectedStatus", "followRedirects", "maxRedirects", "finalUrl",
+	"failuresBeforeAlert", "failureWindow", "retryIntervalInSeconds", "retryInterval",
+	"maxResponseTime", "monitorInterval", "disableInterval", "connectTimeout", "logInterval",
+	"method", "body", "bodyFile", "contentType", "freshCookies", "resetCookiesEvery", "logCookies",
+	"keepAlive", "proxy", "caFile", "clientCert", "clientKey", "minTlsVersion", "insecureSkipVerify",
+}
+
+// webhookOptionNames are the names of the webhook settings, e.g. webhook1.url
+var webhookOptionNames = []string{
+	"url", "template", "templateFile", "contentType", "timeoutInSeconds", "retries", "retryBackoffInSeconds",
+	"secret", "signatureHeader", "events",
+}
+
+var targetNameRegex = regexp.MustCompile(`^monitor\.target(\d+)(\.(.+))?$`)
+var webhookNameRegex = regexp.MustCompile(`^webhook(\d+)\.(.+)$`)
+
+// mailServerCheck is overridden when testing
+var mailServerCheck = func(address string) error {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkConfigFile reports the problems in a config file.  It returns false if there are any.
+func checkConfigFile(fileName string) bool {
+	var props map[string]string
+	var names []string
+	var err error
+	if isYAMLFile(fileName) {
+		props, err = _readYAMLFile(fileName)
+	} else {
+		var list []property
+		list, err = _readProperties(fileName)
+		props = map[string]string{}
+		for _, prop := range list {
+			names = append(names, prop.name)
+			props[prop.name] = prop.value
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading config file "+fileName+":", err)
+		return false
+	}
+
+	problems := checkConfig(props, names)
+	problems = append(problems, _processConfig(props)...)
+	problems = append(problems, checkMailConfig()...)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, "Error:", problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Config file %s has %d errors\n", fileName, len(problems))
+		return false
+	}
+	fmt.Printf("Config file %s is valid, with %d targets\n", fileName, len(targets))
+	return true
+}
+
+// checkConfig returns the unknown names, duplicates, and numbering gaps in the properties.
+// The names are in the order they were read, to find duplicates (nil for a YAML file).
+// Invalid values are found by _processConfig.
+func checkConfig(props map[string]string, names []string) []error {
+	problems := []error{}
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			problems = append(problems, fmt.Errorf("%s is set more than once, the last value is used", name))
+		}
+		seen[name] = true
+	}
+
+	sorted := []string{}
+	for name := range props {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	targetNumbers := map[int]bool{}
+	optionNumbers := map[int]bool{}
+	webhookNumbers := map[int]bool{}
+	assertNumbers := map[string]map[int]bool{}
+	stepNumbers := map[string]map[int]bool{}
+	for _, name := range sorted {
+		if match := targetNameRegex.FindStringSubmatch(name); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			option := match[3]
+			if len(option) == 0 || option == "url" {
+				targetNumbers[n] = true
+			}
+			if len(option) == 0 {
+				continue
+			}
+			optionNumbers[n] = true
+			prefix := "monitor.target" + match[1]
+			if stepMatch := stepNameRegex.FindStringSubmatch(option); stepMatch != nil {
+				if stepNumbers[prefix] == nil {
+					stepNumbers[prefix] = map[int]bool{}
+				}
+				s, _ := strconv.Atoi(stepMatch[1])
+				stepNumbers[prefix][s] = true
+				prefix += ".step" + stepMatch[1]
+				option = stepMatch[2]
+				if strings.HasPrefix(option, "extract.") {
+					continue
+				}
+			}
+			if assertMatch := assertNameRegex.FindStringSubmatch(option); assertMatch != nil {
+				if assertNumbers[prefix] == nil {
+					assertNumbers[prefix] = map[int]bool{}
+				}
+				a, _ := strconv.Atoi(assertMatch[1])
+				assertNumbers[prefix][a] = true
+			} else if !containsString(targetOptionNames, option) && !strings.HasPrefix(option, "header.") {
+				problems = append(problems, unknownName(name, option, targetOptionNames))
+			}
+		} else if match := webhookNameRegex.FindStringSubmatch(name); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			webhookNumbers[n] = true
+			option := match[2]
+			if !containsString(webhookOptionNames, option) && !strings.HasPrefix(option, "header.") {
+				problems = append(problems, unknownName(name, option, webhookOptionNames))
+			}
+		} else if !containsString(configNames, name) {
+			problems = append(problems, unknownName(name, name, configNames))
+		}
+	}
+
+	for n := range optionNumbers {
+		if !targetNumbers[n] {
+			problems = append(problems, fmt.Errorf("monitor.target%d has settings, but no monitor.target%d (or monitor.target%d.url) value", n, n, n))
+		}
+	}
+	problems = append(problems, numberingGaps("monitor.target", targetNumbers)...)
+	for n := range webhookNumbers {
+		if _, ok := props["webhook"+strconv.Itoa(n)+".url"]; !ok {
+			problems = append(problems, fmt.Errorf("webhook%d has settings, but no webhook%d.url value", n, n))
+		}
+	}
+	problems = append(problems, numberingGaps("webhook", webhookNumbers)...)
+	for prefix, numbers := range assertNumbers {
+		problems = append(problems, numberingGaps(prefix+".assert", numbers)...)
+	}
+	for prefix, numbers := range stepNumbers {
+		for s := range numbers {
+			if _, ok := props[prefix+".step"+strconv.Itoa(s)+".url"]; !ok {
+				problems = append(problems, fmt.Errorf("%s.step%d has settings, but no %s.step%d.url value", prefix, s, prefix, s))
+			}
+		}
+		problems = append(problems, numberingGaps(prefix+".step", numbers)...)
+	}
+
+	// Targets with the same host and URL are monitored once
+	first := map[string]int{}
+	for n := 1; targetNumbers[n]; n++ {
+		prefix := "monitor.target" + strconv.Itoa(n)
+		host, url := props[prefix+".host"], props[prefix+".url"]
+		if line, ok := props[prefix]; ok {
+			values := commaSplittingRegex.Split(line, 5)
+			host, url = values[0], ""
+			if len(values) > 1 {
+				url = values[1]
+			}
+		}
+		key := host + "\n" + url
+		if other, ok := first[key]; ok {
+			problems = append(problems, fmt.Errorf("%s has the same host and URL as monitor.target%d", prefix, other))
+		} else {
+			first[key] = n
+		}
+	}
+
+	for _, name := range []string{"slackWebhookUrl", "teamsWebhookUrl", "pagerDutyUrl", "opsgenieUrl"} {
+		if value, ok := props[name]; ok {
+			if err := validateTargetURL(value); err != nil {
+				problems = append(problems, fmt.Errorf("invalid %s value: %s", name, err))
+			}
+		}
+	}
+	for n := range webhookNumbers {
+		name := "webhook" + strconv.Itoa(n) + ".url"
+		if value, ok := props[name]; ok {
+			if err := validateTargetURL(value); err != nil {
+				problems = append(problems, fmt.Errorf("invalid %s value: %s", name, err))
+			}
+		}
+	}
+
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Error() < problems[j].Error() })
+	return problems
+}
+
+// checkMailConfig returns the problems with the mail settings, including a mail server that can't be reached
+func checkMailConfig() []error {
+	if len(mailHost) == 0 {
+		if len(mailTo) > 0 {
+			return []error{fmt.Errorf("mailTo is set, but there is no mailHost to send the alerts to")}
+		}
+		return nil
+	}
+
+	problems := []error{}
+	if len(mailTo) == 0 {
+		problems = append(problems, fmt.Errorf("mailHost is set, but there is no mailTo address"))
+	}
+	if len(mailFrom) == 0 {
+		problems = append(problems, fmt.Errorf("mailHost is set, but there is no mailFrom address"))
+	}
+	address := net.JoinHostPort(mailHost, strconv.Itoa(mailPort))
+	if err := mailServerCheck(address); err != nil {
+		problems = append(problems, fmt.Errorf("mail server %s can't be reached: %s", address, err))
+	}
+	return problems
+}
+
+// numberingGaps returns a problem for each number after a gap, because those are ignored
+func numberingGaps(prefix string, numbers map[int]bool) []error {
+	problems := []error{}
+	for n := range numbers {
+		if n < 1 {
+			problems = append(problems, fmt.Errorf("%s%d is ignored, the numbers start at 1", prefix, n))
+		} else if !numbers[n-1] && n > 1 {
+			problems = append(problems, fmt.Errorf("%s%d and the ones after it are ignored, because there is no %s%d", prefix, n, prefix, n-1))
+		}
+	}
+	return problems
+}
+
+// unknownName returns a problem for a name that is not a setting, suggesting the closest setting
+func unknownName(name, part string, known []string) error {
+	best, bestDistance := "", len(part)/3+2 // further away than this is not a typo
+	for _, candidate := range known {
+		if distance := editDistance(strings.ToLower(part), strings.ToLower(candidate)); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if len(best) == 0 {
+		return fmt.Errorf("unknown setting %s", name)
+	}
+	return fmt.Errorf("unknown setting %s (did you mean %s?)", name, name[:len(name)-len(part)]+best)
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
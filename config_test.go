@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_checkConfig(t *testing.T) {
+	props := map[string]string{
+		"monitorIntervalInMinute":       "3",
+		"mailHost":                      "localhost",
+		"monitor.target1":               "google, https://google.com",
+		"monitor.target1.expectedStatu": "200",
+		"monitor.target2.host":          "google",
+		"monitor.target2.url":           "https://google.com",
+		"monitor.target4":               "four, https://four.example.com",
+		"webhook1.url":                  "chat.example.com/hooks/abc",
+		"webhook1.header.Authorization": "Bearer abc",
+	}
+	names := []string{"mailHost", "monitor.target1", "mailHost"}
+
+	expected := []string{
+		"mailHost is set more than once",
+		"unknown setting monitorIntervalInMinute (did you mean monitorIntervalInMinutes?)",
+		"unknown setting monitor.target1.expectedStatu (did you mean monitor.target1.expectedStatus?)",
+		"monitor.target2 has the same host and URL as monitor.target1",
+		"monitor.target4 and the ones after it are ignored, because there is no monitor.target3",
+		"invalid webhook1.url value",
+	}
+	problems := checkConfig(props, names)
+	if len(problems) != len(expected) {
+		t.Errorf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for _, text := range expected {
+		found := false
+		for _, problem := range problems {
+			found = found || strings.Contains(problem.Error(), text)
+		}
+		if !found {
+			t.Errorf("expected a problem like %q, got %v", text, problems)
+		}
+	}
+
+	if problems := checkConfig(map[string]string{"monitor.target1": "google, https://google.com"}, nil); len(problems) > 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+}
+
+func Test_targetDurations(t *testing.T) {
+	props := map[string]string{
+		"monitor.target1.maxResponseTime": "3s",
+		"monitor.target1.monitorInterval": "30s",
+		"monitor.target1.connectTimeout":  "1m",
+		"monitor.target1.logInterval":     "soon",
+	}
+	target := Target{}
+	problems := _processTargetOptions(props, "monitor.target1", &target)
+	if len(problems) != 1 || !strings.Contains(problems[0].Error(), "monitor.target1.logInterval") {
+		t.Errorf("expected a problem with the logInterval, got %v", problems)
+	}
+	if target.maxResponse() != 3*time.Second || target.nextInterval() != 30*time.Second {
+		t.Errorf("expected the target's durations, got %s and %s", target.maxResponse(), target.nextInterval())
+	}
+	if target.connectTimeoutValue() != 3*time.Second {
+		t.Errorf("expected the connect timeout to be limited to the max response time, got %s", target.connectTimeoutValue())
+	}
+	if target.disableIntervalValue() != disableInterval || target.logIntervalValue() != logInterval {
+		t.Error("expected the global values when the target has none")
+	}
+}
+
+func Test_processConfigTargetOptions(t *testing.T) {
+	props := map[string]string{
+		"monitor.target1":                     "google, https://google.com",
+		"monitor.target1.assert1":             "contains Google",
+		"monitor.target1.assert2":             "json status equals UP",
+		"monitor.target1.expectedStatus":      "200-299",
+		"monitor.target1.followRedirects":     "false",
+		"monitor.target1.failuresBeforeAlert": "3",
+		"monitor.target2.host":                "yaml",
+		"monitor.target2.url":                 "https://example.com/search?q=a,b",
+		"monitor.target2.assert1":             "notContains Error",
+	}
+	saved := currentSettings()
+	defer func() {
+		saved.restore()
+		publishSettings()
+	}()
+	if problems := _processConfig(props); len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(targets))
+	}
+	first := targets[0]
+	if len(first.assertions) != 2 || len(first.expectedStatus) != 1 || !first.noFollowRedirects || first.failuresBeforeAlert != 3 {
+		t.Errorf("expected the options of monitor.target1 to be read, got %+v", first)
+	}
+	if len(targets[1].assertions) != 1 {
+		t.Errorf("expected the assertion of monitor.target2 to be read, got %v", targets[1].assertions)
+	}
+}
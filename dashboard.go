@@ -0,0 +1,303 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sparklineSize  = 60                  // number of recent response times in the sparkline
+	uptimeDuration = 30 * 24 * time.Hour // the longest uptime shown
+)
+
+// hourCount is the number of checks (and failed ones) of a target in one hour
+type hourCount struct {
+	checks   int
+	failures int
+}
+
+// targetStatus is what the dashboard shows for one target
+type targetStatus struct {
+	host         string
+	url          string
+	order        int // when the target was added, the targets of the config file are added in order
+	state        targetState
+	lastCheck    time.Time
+	lastResponse time.Duration
+	lastError    string
+	recent       []time.Duration     // the response times in the sparkline, oldest first
+	hours        map[int64]hourCount // keyed by the unix time of the hour
+}
+
+// statusBoard holds the status of every target, keyed by host and url
+type statusBoard struct {
+	mutex   sync.Mutex
+	targets map[string]*targetStatus
+	added   int // the number of targets added so far, which orders the rows
+}
+
+var dashboard = &statusBoard{targets: map[string]*targetStatus{}}
+
+// status returns the status of the target, adding it if needed.  The caller holds the lock.
+func (b *statusBoard) status(host, url string) *targetStatus {
+	key := host + "\n" + url
+	status, ok := b.targets[key]
+	if !ok {
+		b.added++
+		status = &targetStatus{host: host, url: url, order: b.added, hours: map[int64]hourCount{}}
+		b.targets[key] = status
+	}
+	return status
+}
+
+// dashboardHistory is the check history of a target that its row starts with
+type dashboardHistory struct {
+	rollups []Rollup
+	records []CheckRecord
+}
+
+// loadDashboardHistory reads the history of the target for its uptime and sparkline,
+// when there is a history store.  It reads files, so call it before taking any locks.
+func loadDashboardHistory(target *Target) dashboardHistory {
+	loaded := dashboardHistory{}
+	if history == nil {
+		return loaded
+	}
+	now := time.Now()
+	var err error
+	if loaded.rollups, err = history.Rollups(target.host, target.url, now.Add(-uptimeDuration)); err == nil {
+		// rollups don't include the hours since the history was last pruned
+		since := now.Truncate(time.Hour).Add(-2 * time.Hour)
+		if len(loaded.rollups) > 0 {
+			since = loaded.rollups[len(loaded.rollups)-1].Hour.Add(time.Hour)
+		}
+		loaded.records, err = history.Records(target.host, target.url, since)
+	}
+	if err != nil {
+		log.Println("Error loading check history for the dashboard:", err)
+	}
+	return loaded
+}
+
+// register adds a target before its first check, so every monitored target is shown,
+// starting with the history loaded by loadDashboardHistory
+func (b *statusBoard) register(target *Target, loaded dashboardHistory) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	status := b.status(target.host, target.url)
+	for _, rollup := range loaded.rollups {
+		status.hours[rollup.Hour.Unix()] = hourCount{checks: rollup.Count, failures: rollup.Failures}
+	}
+	for _, record := range loaded.records {
+		status.add(record.Time, record.Duration, len(record.Error) > 0)
+	}
+}
+
+// forget removes a target that is no longer monitored
+func (b *statusBoard) forget(target *Target) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.targets, target.host+"\n"+target.url)
+}
+
+// snapshot returns a copy of the status of a target
+func (b *statusBoard) snapshot(target *Target) (targetStatus, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	status, ok := b.targets[target.host+"\n"+target.url]
+	if !ok {
+		return targetStatus{}, false
+	}
+	return *status, true
+}
+
+// observe records the outcome of a check
+func (b *statusBoard) observe(target *Target, when time.Time, duration time.Duration, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := b.status(target.host, target.url)
+	status.state = target.state
+	status.lastCheck = when
+	status.lastResponse = duration
+	status.lastError = ""
+	if err != nil {
+		status.lastError = err.Error()
+	}
+	status.add(when, duration, err != nil)
+}
+
+// add counts a check in its hour and adds its response time to the sparkline
+func (s *targetStatus) add(when time.Time, duration time.Duration, failed bool) {
+	hour := when.Truncate(time.Hour).Unix()
+	count := s.hours[hour]
+	count.checks++
+	if failed {
+		count.failures++
+	}
+	s.hours[hour] = count
+
+	// forget the hours that are too old to show
+	cutoff := time.Now().Add(-uptimeDuration).Unix()
+	for h := range s.hours {
+		if h < cutoff {
+			delete(s.hours, h)
+		}
+	}
+
+	s.recent = append(s.recent, duration)
+	if len(s.recent) > sparklineSize {
+		s.recent = append([]time.Duration{}, s.recent[len(s.recent)-sparklineSize:]...)
+	}
+}
+
+// uptime returns the percentage of successful checks over the duration, or -1 when there were none
+func (s *targetStatus) uptime(d time.Duration) float64 {
+	cutoff := time.Now().Add(-d).Truncate(time.Hour).Unix()
+	checks, failures := 0, 0
+	for hour, count := range s.hours {
+		if hour >= cutoff {
+			checks += count.checks
+			failures += count.failures
+		}
+	}
+	if checks == 0 {
+		return -1
+	}
+	return 100 * float64(checks-failures) / float64(checks)
+}
+
+// sparkline returns the points of an SVG polyline of the recent response times
+func (s *targetStatus) sparkline(width, height float64) string {
+	if len(s.recent) < 2 {
+		return ""
+	}
+	max := time.Duration(1)
+	for _, d := range s.recent {
+		max = maxDuration(max, d)
+	}
+	points := []string{}
+	step := width / float64(len(s.recent)-1)
+	for i, d := range s.recent {
+		y := height - height*float64(d)/float64(max)
+		points = append(points, fmt.Sprintf("%.1f,%.1f", float64(i)*step, y))
+	}
+	return strings.Join(points, " ")
+}
+
+// dashboardRow is the template data of one target
+type dashboardRow struct {
+	Host         string
+	URL          string
+	State        string
+	LastCheck    string
+	LastResponse string
+	LastError    string
+	Uptime       []string
+	Sparkline    string
+}
+
+func formatUptime(percent float64) string {
+	if percent < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", percent)
+}
+
+// ServeHTTP renders the dashboard
+func (b *statusBoard) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+
+	b.mutex.Lock()
+	statuses := []*targetStatus{}
+	for _, status := range b.targets {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].order < statuses[j].order })
+	rows := []dashboardRow{}
+	for _, s := range statuses {
+		row := dashboardRow{
+			Host:      s.host,
+			URL:       s.url,
+			State:     s.state.String(),
+			LastError: s.lastError,
+			Uptime: []string{
+				formatUptime(s.uptime(24 * time.Hour)),
+				formatUptime(s.uptime(7 * 24 * time.Hour)),
+				formatUptime(s.uptime(uptimeDuration)),
+			},
+			Sparkline: s.sparkline(120, 24),
+		}
+		if !s.lastCheck.IsZero() {
+			row.LastCheck = s.lastCheck.Format("2006-01-02 15:04:05")
+			row.LastResponse = s.lastResponse.Truncate(time.Millisecond).String()
+		}
+		rows = append(rows, row)
+	}
+	b.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, struct {
+		Version string
+		Now     string
+		Rows    []dashboardRow
+	}{version, time.Now().Format("2006-01-02 15:04:05"), rows})
+	if err != nil {
+		log.Println("Error rendering dashboard:", err)
+	}
+}
+
+// dashboardTemplate has no external assets, so it works without internet access
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>web-mon</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 6px 10px; border-bottom: 1px solid #ddd; text-align: left; vertical-align: middle; }
+th { background: #f4f4f4; }
+.state { font-weight: bold; color: #fff; border-radius: 3px; padding: 2px 6px; }
+.UP { background: #2eb886; }
+.DOWN { background: #d00000; }
+.UNKNOWN { background: #999; }
+.error { color: #d00000; font-size: 0.9em; max-width: 30em; }
+.footer { color: #999; font-size: 0.8em; margin-top: 1em; }
+polyline { fill: none; stroke: #36c; stroke-width: 1.5; }
+</style>
+</head>
+<body>
+<h1>web-mon</h1>
+<table>
+<tr><th>State</th><th>Host</th><th>URL</th><th>Last check</th><th>Response</th><th>Recent</th><th>24h</th><th>7d</th><th>30d</th><th>Last error</th></tr>
+{{range .Rows}}<tr>
+<td><span class="state {{.State}}">{{.State}}</span></td>
+<td>{{.Host}}</td>
+<td><a href="{{.URL}}">{{.URL}}</a></td>
+<td>{{.LastCheck}}</td>
+<td>{{.LastResponse}}</td>
+<td>{{if .Sparkline}}<svg width="120" height="24"><polyline points="{{.Sparkline}}"/></svg>{{end}}</td>
+{{range .Uptime}}<td>{{.}}</td>{{end}}
+<td class="error">{{.LastError}}</td>
+</tr>
+{{end}}</table>
+<div class="footer">web-mon {{.Version}} &middot; {{.Now}}</div>
+</body>
+</html>
+`))
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_uptime(t *testing.T) {
+	now := time.Now()
+	status := &targetStatus{hours: map[int64]hourCount{}}
+	if uptime := status.uptime(24 * time.Hour); uptime != -1 {
+		t.Errorf("expected no uptime without checks, got %g", uptime)
+	}
+
+	for i := 0; i < 10; i++ {
+		status.add(now, time.Second, i == 0)
+		status.add(now.Add(-8*24*time.Hour), time.Second, true)
+	}
+	status.add(now.Add(-40*24*time.Hour), time.Second, true) // too old to count
+
+	tests := []struct {
+		duration time.Duration
+		expected float64
+	}{
+		{24 * time.Hour, 90},
+		{7 * 24 * time.Hour, 90},
+		{uptimeDuration, 45},
+	}
+	for _, test := range tests {
+		if uptime := status.uptime(test.duration); uptime != test.expected {
+			t.Errorf("expected an uptime of %g%% over %s, got %g", test.expected, test.duration, uptime)
+		}
+	}
+	if len(status.hours) != 2 {
+		t.Errorf("expected the hours older than %s to be forgotten, got %d hours", uptimeDuration, len(status.hours))
+	}
+}
+
+func Test_sparkline(t *testing.T) {
+	status := &targetStatus{hours: map[int64]hourCount{}}
+	status.add(time.Now(), 0, false)
+	if points := status.sparkline(100, 10); points != "" {
+		t.Errorf("expected no sparkline with one response time, got %s", points)
+	}
+
+	status.add(time.Now(), 50*time.Millisecond, false)
+	status.add(time.Now(), 100*time.Millisecond, false)
+	if points := status.sparkline(100, 10); points != "0.0,10.0 50.0,5.0 100.0,0.0" {
+		t.Errorf("unexpected sparkline: %s", points)
+	}
+
+	for i := 0; i < 2*sparklineSize; i++ {
+		status.add(time.Now(), time.Duration(i)*time.Millisecond, false)
+	}
+	if len(status.recent) != sparklineSize || status.recent[sparklineSize-1] != time.Duration(2*sparklineSize-1)*time.Millisecond {
+		t.Errorf("expected the last %d response times, got %d", sparklineSize, len(status.recent))
+	}
+}
+
+func Test_registerAndForget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileHistoryStore(dir, 7*24*time.Hour, 90*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	savedHistory := history
+	history = store
+	defer func() { history = savedHistory }()
+
+	// The row of a target starts with its history
+	a := &Target{host: "a", url: "https://a"}
+	store.Record(CheckRecord{Time: time.Now().Add(-time.Minute), Host: "a", URL: "https://a", Duration: time.Second})
+	store.Record(CheckRecord{Time: time.Now(), Host: "a", URL: "https://a", Duration: time.Second, Error: "timeout"})
+	board := &statusBoard{targets: map[string]*targetStatus{}}
+	board.register(a, loadDashboardHistory(a))
+	status, ok := board.snapshot(a)
+	if !ok || len(status.recent) != 2 || status.uptime(24*time.Hour) != 50 {
+		t.Errorf("expected the row to start with 2 checks, got %d and %g%% uptime", len(status.recent), status.uptime(24*time.Hour))
+	}
+
+	// Rows keep their order when a target is forgotten and another one added
+	b, c, d := &Target{host: "b"}, &Target{host: "c"}, &Target{host: "d"}
+	board.register(b, dashboardHistory{})
+	board.register(c, dashboardHistory{})
+	board.forget(b)
+	if _, ok := board.snapshot(b); ok {
+		t.Error("expected b to be forgotten")
+	}
+	board.register(d, dashboardHistory{})
+	statusC, _ := board.snapshot(c)
+	statusD, _ := board.snapshot(d)
+	if statusD.order <= statusC.order {
+		t.Errorf("expected d after c, got orders %d and %d", statusC.order, statusD.order)
+	}
+}
@@ -0,0 +1,386 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CheckRecord is the result of one check, as kept by the history store
+type CheckRecord struct {
+	Time       time.Time     `json:"time"`
+	Host       string        `json:"host"`
+	URL        string        `json:"url"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"status,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Phases     PhaseTimings  `json:"phases"`
+}
+
+// Rollup summarizes an hour of checks of one target
+type Rollup struct {
+	Hour          time.Time     `json:"hour"`
+	Host          string        `json:"host"`
+	URL           string        `json:"url"`
+	Count         int           `json:"count"`
+	Failures      int           `json:"failures"`
+	TotalDuration time.Duration `json:"totalDuration"`
+	MaxDuration   time.Duration `json:"maxDuration"`
+	P95Duration   time.Duration `json:"p95Duration"`
+}
+
+// HistoryStore keeps the result of every check.  Raw records are kept for
+// historyRetention and the hourly rollups of them for historyRollupRetention.
+type HistoryStore interface {
+	Record(record CheckRecord) error
+	// Records returns the raw records of a target since the given time, oldest first
+	Records(host, url string, since time.Time) ([]CheckRecord, error)
+	// Rollups returns the hourly rollups of a target since the given time, oldest first
+	Rollups(host, url string, since time.Time) ([]Rollup, error)
+	// Prune rolls up the completed hours and removes what is past its retention
+	Prune(now time.Time) error
+	Close() error
+}
+
+// This is set from the config file, history is not kept when it is nil
+var history HistoryStore
+
+// recordHistory saves the outcome of a check to the history store (if there is one)
+func recordHistory(target *Target, when time.Time, duration time.Duration, err error) {
+	if history == nil {
+		return
+	}
+	record := CheckRecord{
+		Time:       when,
+		Host:       target.host,
+		URL:        target.url,
+		Duration:   duration,
+		StatusCode: target.lastStatus,
+		Phases:     target.phases,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := history.Record(record); err != nil {
+		log.Println("Error recording check history:", err)
+	}
+}
+
+// maintainHistory prunes the history store every hour
+func maintainHistory() {
+	for {
+		if err := history.Prune(time.Now()); err != nil {
+			log.Println("Error pruning check history:", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// fileHistoryStore is the default HistoryStore.  It appends JSON lines to two files in a directory:
+//   checks.jsonl   the raw check records
+//   rollups.jsonl  the hourly rollups
+type fileHistoryStore struct {
+	mutex            sync.Mutex // held to append to the files, or to replace them
+	pruning          sync.Mutex // held by Prune, so only one prunes the files at a time
+	checksPath       string
+	rollupsPath      string
+	checks           *os.File
+	rollups          *os.File
+	retention        time.Duration
+	rollupRetention  time.Duration
+	lastRolledUpHour time.Time // the most recent hour in the rollups file
+}
+
+// NewFileHistoryStore opens (or creates) the history files in the directory
+func NewFileHistoryStore(directory string, retention, rollupRetention time.Duration) (HistoryStore, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	s := &fileHistoryStore{
+		checksPath:      filepath.Join(directory, "checks.jsonl"),
+		rollupsPath:     filepath.Join(directory, "rollups.jsonl"),
+		retention:       retention,
+		rollupRetention: rollupRetention,
+	}
+
+	// Find where the rollups left off
+	err := readJSONLines(s.rollupsPath, func(line []byte) {
+		var rollup Rollup
+		if json.Unmarshal(line, &rollup) == nil && rollup.Hour.After(s.lastRolledUpHour) {
+			s.lastRolledUpHour = rollup.Hour
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens both files for appending
+func (s *fileHistoryStore) open() error {
+	var err error
+	if s.checks, err = os.OpenFile(s.checksPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return err
+	}
+	if s.rollups, err = os.OpenFile(s.rollupsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		s.checks.Close()
+		return err
+	}
+	return nil
+}
+
+// Record is part of the HistoryStore interface
+func (s *fileHistoryStore) Record(record CheckRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return appendJSONLine(s.checks, record)
+}
+
+// Records is part of the HistoryStore interface.  The file is read without the lock, so the checks
+// being recorded don't wait for it.  A record that is still being written is skipped.
+func (s *fileHistoryStore) Records(host, url string, since time.Time) ([]CheckRecord, error) {
+	records := []CheckRecord{}
+	err := readJSONLines(s.checksPath, func(line []byte) {
+		var record CheckRecord
+		if json.Unmarshal(line, &record) == nil && record.Host == host && record.URL == url && !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	})
+	return records, err
+}
+
+// Rollups is part of the HistoryStore interface.  The file is read without the lock,
+// it only changes when Prune renames a new file over it.
+func (s *fileHistoryStore) Rollups(host, url string, since time.Time) ([]Rollup, error) {
+	rollups := []Rollup{}
+	err := readJSONLines(s.rollupsPath, func(line []byte) {
+		var rollup Rollup
+		if json.Unmarshal(line, &rollup) == nil && rollup.Host == host && rollup.URL == url && !rollup.Hour.Before(since) {
+			rollups = append(rollups, rollup)
+		}
+	})
+	return rollups, err
+}
+
+// Prune is part of the HistoryStore interface.  The files are read and the new ones written without
+// the lock, which is only held to copy the records added in the meantime and to rename the new files.
+func (s *fileHistoryStore) Prune(now time.Time) error {
+	s.pruning.Lock()
+	defer s.pruning.Unlock()
+
+	// The checks file holds whole records up to its current size
+	s.mutex.Lock()
+	info, err := s.checks.Stat()
+	lastRolledUpHour := s.lastRolledUpHour
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	currentHour := now.Truncate(time.Hour)
+	rawCutoff := now.Add(-s.retention)
+	rollupCutoff := now.Add(-s.rollupRetention)
+
+	// Read the raw records, keeping the recent ones and grouping the
+	// ones in completed hours that haven't been rolled up yet
+	kept := []CheckRecord{}
+	hours := map[string][]CheckRecord{}
+	err = readJSONLinesUpTo(s.checksPath, size, func(line []byte) {
+		var record CheckRecord
+		if json.Unmarshal(line, &record) != nil {
+			return
+		}
+		hour := record.Time.Truncate(time.Hour)
+		if hour.Before(currentHour) && hour.After(lastRolledUpHour) {
+			key := fmt.Sprintf("%d\n%s\n%s", hour.Unix(), record.Host, record.URL)
+			hours[key] = append(hours[key], record)
+		}
+		if record.Time.After(rawCutoff) {
+			kept = append(kept, record)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Read the rollups, keeping the recent ones, then add the new ones
+	keptRollups := []Rollup{}
+	err = readJSONLines(s.rollupsPath, func(line []byte) {
+		var rollup Rollup
+		if json.Unmarshal(line, &rollup) == nil && rollup.Hour.After(rollupCutoff) {
+			keptRollups = append(keptRollups, rollup)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	newRollups := []Rollup{}
+	for _, records := range hours {
+		newRollups = append(newRollups, rollupRecords(records))
+	}
+	sort.Slice(newRollups, func(i, j int) bool { return newRollups[i].Hour.Before(newRollups[j].Hour) })
+	for _, rollup := range newRollups {
+		keptRollups = append(keptRollups, rollup)
+		if rollup.Hour.After(lastRolledUpHour) {
+			lastRolledUpHour = rollup.Hour
+		}
+	}
+
+	// Write both new files before replacing either one, so a failure leaves the old ones as they were
+	checksTmpPath, rollupsTmpPath := s.checksPath+".tmp", s.rollupsPath+".tmp"
+	if err := writeJSONLines(rollupsTmpPath, len(keptRollups), func(i int) interface{} { return keptRollups[i] }); err != nil {
+		os.Remove(rollupsTmpPath)
+		return err
+	}
+	if err := writeJSONLines(checksTmpPath, len(kept), func(i int) interface{} { return kept[i] }); err != nil {
+		os.Remove(rollupsTmpPath)
+		os.Remove(checksTmpPath)
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := appendFileFrom(s.checksPath, size, checksTmpPath); err != nil {
+		os.Remove(rollupsTmpPath)
+		os.Remove(checksTmpPath)
+		return err
+	}
+
+	// The rollups are replaced first.  If replacing the checks fails, their raw records are kept
+	// a while longer, and only now are the hours rolled up, so the next prune doesn't roll them up again.
+	s.checks.Close()
+	s.rollups.Close()
+	if err := os.Rename(rollupsTmpPath, s.rollupsPath); err != nil {
+		os.Remove(checksTmpPath)
+		s.open()
+		return err
+	}
+	s.lastRolledUpHour = lastRolledUpHour
+	if err := os.Rename(checksTmpPath, s.checksPath); err != nil {
+		s.open()
+		return err
+	}
+	return s.open()
+}
+
+// Close is part of the HistoryStore interface
+func (s *fileHistoryStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rollups.Close()
+	return s.checks.Close()
+}
+
+// rollupRecords summarizes the records of one target in one hour
+func rollupRecords(records []CheckRecord) Rollup {
+	first := records[0]
+	rollup := Rollup{Hour: first.Time.Truncate(time.Hour), Host: first.Host, URL: first.URL}
+	durations := []time.Duration{}
+	for _, record := range records {
+		rollup.Count++
+		if len(record.Error) > 0 {
+			rollup.Failures++
+		}
+		rollup.TotalDuration += record.Duration
+		rollup.MaxDuration = maxDuration(rollup.MaxDuration, record.Duration)
+		durations = append(durations, record.Duration)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rollup.P95Duration = durations[int(math.Ceil(0.95*float64(len(durations))))-1]
+	return rollup
+}
+
+// appendJSONLine writes the value to the file as one line of JSON
+func appendJSONLine(file *os.File, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(bytes, '\n'))
+	return err
+}
+
+// readJSONLines calls fn with each line of the file.  A missing file has no lines.
+func readJSONLines(path string, fn func(line []byte)) error {
+	return readJSONLinesUpTo(path, math.MaxInt64, fn)
+}
+
+// readJSONLinesUpTo is readJSONLines for the first size bytes of the file
+func readJSONLinesUpTo(path string, size int64, fn func(line []byte)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(file, size))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fn(scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+// writeJSONLines creates the file with the given values, one line of JSON each
+func writeJSONLines(path string, count int, value func(i int) interface{}) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for i := 0; i < count; i++ {
+		bytes, err := json.Marshal(value(i))
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(bytes)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// appendFileFrom appends what follows the offset in the file at path to the file at destination
+func appendFileFrom(path string, offset int64, destination string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if _, err := source.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(destination, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, source); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_rollupRecords(t *testing.T) {
+	hour := time.Date(2030, 1, 2, 11, 0, 0, 0, time.UTC)
+	records := []CheckRecord{}
+	for i := 20; i > 0; i-- {
+		record := CheckRecord{Time: hour.Add(time.Duration(i) * time.Minute), Host: "a", URL: "https://a", Duration: time.Duration(i) * time.Millisecond}
+		if i%7 == 0 || i == 1 {
+			record.Error = "HTTP Error code: 503"
+		}
+		records = append(records, record)
+	}
+
+	rollup := rollupRecords(records)
+	expected := Rollup{Hour: hour, Host: "a", URL: "https://a", Count: 20, Failures: 3,
+		TotalDuration: 210 * time.Millisecond, MaxDuration: 20 * time.Millisecond, P95Duration: 19 * time.Millisecond}
+	if rollup != expected {
+		t.Errorf("expected %+v, got %+v", expected, rollup)
+	}
+
+	// With one record, it is the max and the 95th percentile
+	rollup = rollupRecords(records[:1])
+	if rollup.Count != 1 || rollup.P95Duration != 20*time.Millisecond || rollup.MaxDuration != 20*time.Millisecond {
+		t.Errorf("unexpected rollup of one record: %+v", rollup)
+	}
+}
+
+func Test_historyPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileHistoryStore(dir, 2*time.Hour, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+	at := func(hour, minute int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+	for _, record := range []CheckRecord{
+		{Time: at(8, 10), Host: "a", URL: "https://a", Duration: time.Second},
+		{Time: at(8, 40), Host: "a", URL: "https://a", Duration: 3 * time.Second, Error: "timeout"},
+		{Time: at(11, 15), Host: "a", URL: "https://a", Duration: time.Second},
+		{Time: at(11, 20), Host: "b", URL: "https://b", Duration: time.Second},
+		{Time: at(12, 5), Host: "a", URL: "https://a", Duration: time.Second},
+	} {
+		if err := store.Record(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hoursOf := func(host string) []int {
+		rollups, err := store.Rollups(host, "https://"+host, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hours := []int{}
+		for _, rollup := range rollups {
+			hours = append(hours, rollup.Hour.Hour())
+		}
+		return hours
+	}
+
+	// The completed hours are rolled up, and the raw records past their retention are removed
+	if err := store.Prune(at(12, 30)); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := store.Records("a", "https://a", time.Time{})
+	if len(records) != 2 || !records[0].Time.Equal(at(11, 15)) {
+		t.Errorf("expected the records since 10:30 to be kept, got %v", records)
+	}
+	rollups, _ := store.Rollups("a", "https://a", time.Time{})
+	if len(rollups) != 2 || rollups[0].Count != 2 || rollups[0].Failures != 1 || rollups[0].MaxDuration != 3*time.Second {
+		t.Errorf("expected rollups of 8:00 and 11:00, got %+v", rollups)
+	}
+	if hours := hoursOf("b"); len(hours) != 1 || hours[0] != 11 {
+		t.Errorf("expected a rollup of 11:00 for b, got %v", hours)
+	}
+
+	// An hour is only rolled up once, even by a store opened again
+	if err := store.Prune(at(12, 45)); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if store, err = NewFileHistoryStore(dir, 2*time.Hour, 48*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Prune(at(13, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if hours := hoursOf("a"); len(hours) != 3 || hours[0] != 8 || hours[1] != 11 || hours[2] != 12 {
+		t.Errorf("expected rollups of 8:00, 11:00, and 12:00, got %v", hours)
+	}
+
+	// Rollups are removed after their own retention
+	if err := store.Prune(at(59, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if hours := hoursOf("a"); len(hours) != 1 || hours[0] != 12 {
+		t.Errorf("expected only the rollup of 12:00 to be kept, got %v", hours)
+	}
+	if records, _ := store.Records("a", "https://a", time.Time{}); len(records) != 0 {
+		t.Errorf("expected no raw records, got %d", len(records))
+	}
+}
+
+func Test_historyPruneFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileHistoryStore(dir, time.Hour, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Date(2030, 1, 2, 12, 30, 0, 0, time.UTC)
+	store.Record(CheckRecord{Time: now.Add(-3 * time.Hour), Host: "a", URL: "https://a", Duration: time.Second})
+	store.Record(CheckRecord{Time: now, Host: "a", URL: "https://a", Duration: time.Second})
+
+	// When the rollups can't be written, the raw records are kept
+	blocker := filepath.Join(dir, "rollups.jsonl.tmp")
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Prune(now); err == nil {
+		t.Error("expected the prune to fail")
+	}
+	if records, _ := store.Records("a", "https://a", time.Time{}); len(records) != 2 {
+		t.Errorf("expected the 2 raw records to be kept, got %d", len(records))
+	}
+
+	// The next prune rolls them up, and keeps the records added since
+	os.Remove(blocker)
+	store.Record(CheckRecord{Time: now.Add(time.Minute), Host: "a", URL: "https://a", Duration: time.Second})
+	if err := store.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := store.Records("a", "https://a", time.Time{})
+	rollups, _ := store.Rollups("a", "https://a", time.Time{})
+	if len(records) != 2 || len(rollups) != 1 || rollups[0].Count != 1 {
+		t.Errorf("expected 2 records and a rollup of 1 check, got %d and %+v", len(records), rollups)
+	}
+}
+
+func Test_appendFileFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source, destination := filepath.Join(dir, "checks.jsonl"), filepath.Join(dir, "checks.jsonl.tmp")
+	ioutil.WriteFile(source, []byte("read\nadded\n"), 0644)
+	ioutil.WriteFile(destination, []byte("kept\n"), 0644)
+
+	// The records added to the checks file while it was pruned are copied to the new file
+	if err := appendFileFrom(source, int64(len("read\n")), destination); err != nil {
+		t.Fatal(err)
+	}
+	if bytes, _ := ioutil.ReadFile(destination); string(bytes) != "kept\nadded\n" {
+		t.Errorf("unexpected file: %q", bytes)
+	}
+}
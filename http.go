@@ -1,60 +1,229 @@
 //
-// NewTimeoutClient and TimeoutDialer copied directly from
-// http://stackoverflow.com/questions/16895294/how-to-set-timeout-for-http-get-requests-in-golang
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
 //
 package main
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
-)
 
-var (
-	cookieJar = &CookieJar{jar: make(map[string][]*http.Cookie)}
+	"golang.org/x/net/publicsuffix"
 )
 
-// NewTimeoutClient returns a client that will timeout and keep in-memory cookies
-func NewTimeoutClient(connectTimeout time.Duration, readWriteTimeout time.Duration) *http.Client {
+// transportSettings are what a transport is built from.  A target keeps a transport for each
+// combination it uses (its steps can have their own), so connections can be kept alive between checks.
+type transportSettings struct {
+	connectTimeout time.Duration
+	idleTimeout    time.Duration // how long an unused connection is kept
+	keepAlive      bool          // false opens a new connection for every request
+	connection     connectionOptions
+}
 
-	return &http.Client{
-		Transport: &http.Transport{
-			Dial: TimeoutDialer(connectTimeout, readWriteTimeout),
-		},
-		Jar: cookieJar,
+// transportSettings returns the transport settings of the target
+func (t *Target) transportSettings() transportSettings {
+	return transportSettings{
+		connectTimeout: t.connectTimeoutValue(),
+		idleTimeout:    durationOrDefault(t.monitorInterval, live().monitorInterval) + 30*time.Second,
+		keepAlive:      !t.noKeepAlive,
+		connection:     t.connection,
 	}
 }
 
-// TimeoutDialer returns a connection with both a DialTimeout and a deadline for completing
-func TimeoutDialer(cTimeout time.Duration, rwTimeout time.Duration) func(net, addr string) (c net.Conn, err error) {
-	return func(netw, addr string) (net.Conn, error) {
-		conn, err := net.DialTimeout(netw, addr, cTimeout)
-		if err != nil {
-			return nil, err
+// transportPool holds the transports of a target.  The transports can be shared with
+// the steps, and copies of the target, hence the lock.
+type transportPool struct {
+	mutex      sync.Mutex
+	transports map[transportSettings]*pooledTransport
+}
+
+// pooledTransport is a transport, with the modification times of the certificate files it was built with
+type pooledTransport struct {
+	transport *http.Transport
+	fileTimes certFileTimes
+}
+
+// get returns the transport for the settings, creating it the first time, and again when one of its certificate files changed
+func (p *transportPool) get(settings transportSettings) (*http.Transport, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.transports == nil {
+		p.transports = map[transportSettings]*pooledTransport{}
+	}
+	fileTimes := settings.connection.fileTimes()
+	if pooled, ok := p.transports[settings]; ok {
+		if pooled.fileTimes == fileTimes {
+			return pooled.transport, nil
 		}
-		conn.SetDeadline(time.Now().Add(rwTimeout))
-		return conn, nil
+		pooled.transport.CloseIdleConnections()
+		delete(p.transports, settings)
+	}
+	transport, err := newTransport(settings)
+	if err != nil {
+		return nil, err
 	}
+	p.transports[settings] = &pooledTransport{transport: transport, fileTimes: fileTimes}
+	return transport, nil
 }
 
-// CookieJar holds cookies
-type CookieJar struct {
-	jar map[string][]*http.Cookie
+// retain drops the transports of the settings that are no longer used, and closes their connections
+func (p *transportPool) retain(used []transportSettings) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for settings, pooled := range p.transports {
+		if !containsTransportSettings(used, settings) {
+			pooled.transport.CloseIdleConnections()
+			delete(p.transports, settings)
+		}
+	}
 }
 
-// SetCookies is part of the http.CookieJar interface
-func (p *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
-	if verbose {
-		//fmt.Printf("The cookies being set for url %s are: %s\n", u.String(), cookies)
+// closeIdle closes the connections that are not in use
+func (p *transportPool) closeIdle() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, pooled := range p.transports {
+		pooled.transport.CloseIdleConnections()
+	}
+}
+
+func containsTransportSettings(list []transportSettings, settings transportSettings) bool {
+	for _, s := range list {
+		if s == settings {
+			return true
+		}
+	}
+	return false
+}
+
+// newTransport returns a transport that times out connecting.  The time allowed for the
+// whole request is set by the context of each request, so a slow body is read until then.
+func newTransport(settings transportSettings) (*http.Transport, error) {
+	proxy, err := settings.connection.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := settings.connection.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: settings.connectTimeout, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:             proxy,
+		DialContext:       dialer.DialContext,
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: !settings.keepAlive,
+		IdleConnTimeout:   settings.idleTimeout,
+	}, nil
+}
+
+// transportPool returns the transports of the target
+func (t *Target) transportPool() *transportPool {
+	if t.transports == nil {
+		t.transports = &transportPool{}
+	}
+	return t.transports
+}
+
+// usedTransportSettings returns the transport settings of the target's request, or of each of its steps
+func (t *Target) usedTransportSettings() []transportSettings {
+	if len(t.steps) == 0 {
+		return []transportSettings{t.transportSettings()}
+	}
+	used := []transportSettings{}
+	for i := range t.steps {
+		step := t.stepRequest(i, map[string]string{})
+		used = append(used, step.transportSettings())
+	}
+	return used
+}
+
+// newClient returns a client for one check of the target, with the target's transport and cookies
+func (t *Target) newClient() (*http.Client, error) {
+	transport, err := t.transportPool().get(t.transportSettings())
+	if err != nil {
+		return nil, err
 	}
-	p.jar[u.Host] = cookies
+	return &http.Client{Transport: transport, Jar: t.cookies()}, nil
+}
+
+// targetJar holds the cookies of one target, with the expiry, path, and domain rules of RFC 6265.
+// It can be emptied every few checks, so a session doesn't last forever.
+type targetJar struct {
+	mutex  sync.Mutex
+	jar    *cookiejar.Jar
+	checks int                 // checks since the jar was emptied
+	urls   map[string]*url.URL // where cookies were set, to list them
+}
+
+func newTargetJar() *targetJar {
+	j := &targetJar{}
+	j.reset()
+	return j
+}
+
+// reset empties the jar
+func (j *targetJar) reset() {
+	// The public suffix list keeps a server from setting cookies for a whole domain like co.uk
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List}) // never fails
+	j.jar = jar
+	j.checks = 0
+	j.urls = map[string]*url.URL{}
+}
+
+// startCheck empties the jar when a check should start without cookies, which is every check
+// when fresh is true, or every resetEvery checks (never when it is zero)
+func (j *targetJar) startCheck(fresh bool, resetEvery int) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if fresh || (resetEvery > 0 && j.checks >= resetEvery) {
+		j.reset()
+	}
+	j.checks++
+}
+
+// SetCookies is part of the http.CookieJar interface
+func (j *targetJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.jar.SetCookies(u, cookies)
+	j.urls[u.Scheme+"://"+u.Host+u.Path] = u
 }
 
 // Cookies is part of the http.CookieJar interface
-func (p *CookieJar) Cookies(u *url.URL) []*http.Cookie {
-	if verbose {
-		//fmt.Printf("The cookies being returned for url %s are: %s\n", u.String(), p.jar[u.Host])
+func (j *targetJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.jar.Cookies(u)
+}
+
+// String lists the cookies that would be sent to each URL that set cookies
+func (j *targetJar) String() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	names := []string{}
+	for name := range j.urls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{}
+	for _, name := range names {
+		cookies := []string{}
+		for _, cookie := range j.jar.Cookies(j.urls[name]) {
+			cookies = append(cookies, cookie.String())
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", name, strings.Join(cookies, "; ")))
+	}
+	if len(lines) == 0 {
+		return "none"
 	}
-	return p.jar[u.Host]
+	return strings.Join(lines, ", ")
 }
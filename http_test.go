@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_targetJar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/app"})
+			http.SetCookie(w, &http.Cookie{Name: "old", Value: "x", MaxAge: -1})
+		case "/app/home", "/other":
+			if cookie, err := r.Cookie("session"); err == nil {
+				w.Header().Set("X-Session", cookie.Value)
+			}
+			if _, err := r.Cookie("old"); err == nil {
+				t.Error("expected the expired cookie not to be sent")
+			}
+		}
+	}))
+	defer server.Close()
+
+	check := func(target *Target, path string) string {
+		target.url = server.URL + path
+		target.cookies().startCheck(target.freshCookies, target.resetCookiesEvery)
+		_, header, err := doRequest(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return header.Get("X-Session")
+	}
+
+	one := &Target{host: "one", resetCookiesEvery: 3}
+	check(one, "/login")
+	if session := check(one, "/app/home"); session != "abc" {
+		t.Errorf("expected the session cookie to be sent, got %q", session)
+	}
+	if session := check(one, "/other"); session != "" {
+		t.Errorf("expected the cookie to only be sent to its path, got %q", session)
+	}
+	if session := check(&Target{host: "two"}, "/app/home"); session != "" {
+		t.Errorf("expected targets not to share cookies, got %q", session)
+	}
+	if session := check(one, "/app/home"); session != "" {
+		t.Errorf("expected the jar to be emptied after 3 checks, got %q", session)
+	}
+
+	fresh := &Target{host: "fresh", freshCookies: true}
+	check(fresh, "/login")
+	if session := check(fresh, "/app/home"); session != "" {
+		t.Errorf("expected every check to start without cookies, got %q", session)
+	}
+}
+
+func Test_transportReuse(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			w.Write([]byte("start"))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	countConnections := func(target *Target) int32 {
+		atomic.StoreInt32(&connections, 0)
+		for i := 0; i < 3; i++ {
+			if _, _, err := doRequest(target); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return atomic.LoadInt32(&connections)
+	}
+	if n := countConnections(&Target{host: "warm", url: server.URL}); n != 1 {
+		t.Errorf("expected the connection to be reused, got %d connections", n)
+	}
+	if n := countConnections(&Target{host: "cold", url: server.URL, noKeepAlive: true}); n != 3 {
+		t.Errorf("expected a new connection for each request, got %d connections", n)
+	}
+
+	// A body that is still arriving when the max response time is up is slow
+	slow := &Target{host: "slow", url: server.URL + "/slow", maxResponseTime: 100 * time.Millisecond}
+	if _, _, err := doRequest(slow); err == nil || !isSlow(err) {
+		t.Errorf("expected a slow response, got %v", err)
+	}
+}
+
+func Test_connectionOptions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, bundle, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(props map[string]string) error {
+		target := Target{host: "tls", url: server.URL}
+		if problems := _processTargetOptions(props, "monitor.target1", &target); len(problems) > 0 {
+			t.Fatal(problems)
+		}
+		_, _, err := doRequest(&target)
+		return err
+	}
+	if err := check(map[string]string{}); err == nil {
+		t.Error("expected the test server's certificate not to be trusted")
+	}
+	if err := check(map[string]string{"monitor.target1.caFile": caFile, "monitor.target1.minTlsVersion": "1.2"}); err != nil {
+		t.Errorf("expected the CA bundle to be trusted, got %s", err)
+	}
+	if err := check(map[string]string{"monitor.target1.insecureSkipVerify": "true"}); err != nil {
+		t.Errorf("expected the certificate not to be verified, got %s", err)
+	}
+
+	// A plain http request goes to the proxy with the whole URL
+	proxied := ""
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	target := Target{host: "proxied", url: "http://internal.example.com/ping",
+		connection: connectionOptions{proxy: strings.Replace(proxy.URL, "http://", "http://joe:secret@", 1)}}
+	if _, _, err := doRequest(&target); err != nil || proxied != target.url {
+		t.Errorf("expected the request to go through the proxy, got %q %v", proxied, err)
+	}
+	if description := target.connection.String(); strings.Contains(description, "secret") {
+		t.Errorf("expected the proxy password to be redacted: %s", description)
+	}
+
+	invalid := map[string]string{
+		"monitor.target1.proxy":         "ftp://proxy.example.com",
+		"monitor.target1.caFile":        filepath.Join(dir, "missing.pem"),
+		"monitor.target1.clientCert":    caFile,
+		"monitor.target1.minTlsVersion": "1.4",
+	}
+	if problems := _processTargetOptions(invalid, "monitor.target1", &Target{}); len(problems) != 3 {
+		t.Errorf("expected 3 problems, got %d: %v", len(problems), problems)
+	}
+}
+
+func Test_transportPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, bundle, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	target := &Target{host: "tls", url: server.URL, connection: connectionOptions{caFile: caFile}}
+	pool := target.transportPool()
+	first, err := pool.get(target.transportSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := pool.get(target.transportSettings()); again != first {
+		t.Error("expected the transport to be reused")
+	}
+
+	// A replaced CA bundle is read again
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(caFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := pool.get(target.transportSettings())
+	if err != nil || renewed == first || len(pool.transports) != 1 {
+		t.Errorf("expected a new transport for the new CA bundle, got %d transports: %v", len(pool.transports), err)
+	}
+
+	// The transports of the settings the target no longer uses are dropped
+	old := target.transportSettings()
+	target.connectTimeout = 5 * time.Second
+	if _, err := pool.get(target.transportSettings()); err != nil {
+		t.Fatal(err)
+	}
+	pool.retain(target.usedTransportSettings())
+	if _, ok := pool.transports[old]; ok || len(pool.transports) != 1 {
+		t.Errorf("expected only the transport of the new settings, got %d transports", len(pool.transports))
+	}
+}
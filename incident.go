@@ -0,0 +1,173 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	defaultOpsgenieURL  = "https://api.opsgenie.com"
+)
+
+// dedupKey returns a stable identifier for the target's incidents, so a recovery
+// resolves the incident opened by the alert (and repeat alerts don't open new ones)
+func dedupKey(host, targetURL string) string {
+	sum := sha256.Sum256([]byte(host + "\n" + targetURL))
+	return "web-mon-" + hex.EncodeToString(sum[:8])
+}
+
+// PagerDutyNotifier opens and resolves incidents with the PagerDuty Events API v2
+type PagerDutyNotifier struct {
+	routingKey string
+	url        string
+	retries    int
+	backoff    time.Duration
+}
+
+// NewPagerDutyNotifier creates a PagerDuty notifier for the integration's routing key
+func NewPagerDutyNotifier(routingKey string) *PagerDutyNotifier {
+	return &PagerDutyNotifier{routingKey: routingKey, url: defaultPagerDutyURL, retries: 3, backoff: time.Second}
+}
+
+// Name is part of the Notifier interface
+func (p *PagerDutyNotifier) Name() string {
+	return "pagerduty"
+}
+
+// Notify is part of the Notifier interface
+func (p *PagerDutyNotifier) Notify(event *Event) error {
+	message := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"dedup_key":    event.DedupKey,
+		"event_action": "trigger",
+	}
+	if event.Kind == eventRecovery {
+		message["event_action"] = "resolve"
+	} else {
+		severity := "critical"
+		if event.Severity == severitySlow || event.Severity == severityWarning {
+			severity = "warning"
+		}
+		message["payload"] = map[string]interface{}{
+			"summary":   truncate(event.Subject, 1024),
+			"source":    event.Host,
+			"severity":  severity,
+			"timestamp": event.Time.Format(time.RFC3339),
+			"component": event.URL,
+			"custom_details": map[string]string{
+				"error":         event.Error,
+				"response_time": event.ResponseTime.String(),
+				"phases":        event.Phases.String(),
+				"stats":         event.Stats.String(),
+			},
+		}
+		message["links"] = []interface{}{map[string]string{"href": event.URL, "text": event.Host}}
+	}
+	return p.post(p.url, message)
+}
+
+func (p *PagerDutyNotifier) post(url string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return postWithRetries(p.Name(), 10*time.Second, p.retries, p.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
+
+// OpsgenieNotifier creates and closes alerts with the Opsgenie Alert API
+type OpsgenieNotifier struct {
+	apiKey  string
+	url     string // base URL of the API
+	retries int
+	backoff time.Duration
+}
+
+// NewOpsgenieNotifier creates an Opsgenie notifier for the integration's API key
+func NewOpsgenieNotifier(apiKey string) *OpsgenieNotifier {
+	return &OpsgenieNotifier{apiKey: apiKey, url: defaultOpsgenieURL, retries: 3, backoff: time.Second}
+}
+
+// Name is part of the Notifier interface
+func (o *OpsgenieNotifier) Name() string {
+	return "opsgenie"
+}
+
+// Notify is part of the Notifier interface
+func (o *OpsgenieNotifier) Notify(event *Event) error {
+	base := strings.TrimRight(o.url, "/")
+	if event.Kind == eventRecovery {
+		closeURL := base + "/v2/alerts/" + url.PathEscape(event.DedupKey) + "/close?identifierType=alias"
+		return o.post(closeURL, map[string]string{
+			"source": "web-mon",
+			"note":   event.Subject,
+		})
+	}
+
+	priority := "P1"
+	if event.Severity == severitySlow {
+		priority = "P3"
+	} else if event.Severity == severityWarning {
+		priority = "P4"
+	}
+	return o.post(base+"/v2/alerts", map[string]interface{}{
+		"message":     truncate(event.Subject, 130),
+		"alias":       event.DedupKey,
+		"description": event.Subject + "\n\n" + event.Stats.String(),
+		"priority":    priority,
+		"source":      "web-mon",
+		"entity":      event.Host,
+		"details": map[string]string{
+			"url":          event.URL,
+			"error":        event.Error,
+			"responseTime": event.ResponseTime.String(),
+			"phases":       event.Phases.String(),
+		},
+	})
+}
+
+func (o *OpsgenieNotifier) post(url string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return postWithRetries(o.Name(), 10*time.Second, o.retries, o.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "GenieKey "+o.apiKey)
+		return req, nil
+	})
+}
+
+// truncate shortens s to at most max bytes without splitting a character, the incident APIs reject longer values
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
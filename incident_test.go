@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// eventsStandIn is a local stand-in for an incident API.  It fails the first
+// failures requests with the given status code, then accepts the rest.
+type eventsStandIn struct {
+	mutex    sync.Mutex
+	failures int
+	status   int
+	requests []*http.Request
+	bodies   []map[string]interface{}
+}
+
+func (s *eventsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	body := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&body)
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(s.status)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func testEvents() (*Event, *Event) {
+	target := &Target{host: "tst-123", url: "https://tst-123/api/Ping", err: errors.New("HTTP Error code: 503")}
+	alert := newEvent(eventAlert, target, alertSubject(target))
+	target.err = nil
+	recovery := newEvent(eventRecovery, target, "RECOVERED")
+	return alert, recovery
+}
+
+func Test_pagerDutyTriggerAndResolve(t *testing.T) {
+	standIn := &eventsStandIn{failures: 2, status: http.StatusTooManyRequests}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	pagerDuty := NewPagerDutyNotifier("routing-key")
+	pagerDuty.url = server.URL
+	pagerDuty.backoff = 0
+
+	alert, recovery := testEvents()
+	if err := pagerDuty.Notify(alert); err != nil {
+		t.Fatalf("trigger failed: %s", err)
+	}
+	if err := pagerDuty.Notify(recovery); err != nil {
+		t.Fatalf("resolve failed: %s", err)
+	}
+
+	// Two 429 responses were retried, then the trigger and resolve were accepted
+	if len(standIn.bodies) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(standIn.bodies))
+	}
+	trigger, resolve := standIn.bodies[2], standIn.bodies[3]
+	if trigger["event_action"] != "trigger" || resolve["event_action"] != "resolve" {
+		t.Errorf("unexpected event actions: %v, %v", trigger["event_action"], resolve["event_action"])
+	}
+	if trigger["dedup_key"] != resolve["dedup_key"] || trigger["dedup_key"] == "" {
+		t.Errorf("dedup keys should match: %v, %v", trigger["dedup_key"], resolve["dedup_key"])
+	}
+}
+
+func Test_pagerDutyGivesUp(t *testing.T) {
+	standIn := &eventsStandIn{failures: 10, status: http.StatusBadGateway}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	pagerDuty := NewPagerDutyNotifier("routing-key")
+	pagerDuty.url = server.URL
+	pagerDuty.backoff = 0
+
+	alert, _ := testEvents()
+	if err := pagerDuty.Notify(alert); err == nil {
+		t.Fatal("expected an error after the retries ran out")
+	}
+	if len(standIn.requests) != pagerDuty.retries+1 {
+		t.Errorf("expected %d requests, got %d", pagerDuty.retries+1, len(standIn.requests))
+	}
+
+	// Client errors are not retried
+	standIn = &eventsStandIn{failures: 10, status: http.StatusBadRequest}
+	server2 := httptest.NewServer(standIn)
+	defer server2.Close()
+	pagerDuty.url = server2.URL
+	if err := pagerDuty.Notify(alert); err == nil || len(standIn.requests) != 1 {
+		t.Errorf("expected one failed request, got %d: %v", len(standIn.requests), err)
+	}
+}
+
+func Test_opsgenieCreateAndClose(t *testing.T) {
+	standIn := &eventsStandIn{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	opsgenie := NewOpsgenieNotifier("api-key")
+	opsgenie.url = server.URL
+	opsgenie.backoff = 0
+
+	alert, recovery := testEvents()
+	if err := opsgenie.Notify(alert); err != nil {
+		t.Fatalf("create failed: %s", err)
+	}
+	if err := opsgenie.Notify(recovery); err != nil {
+		t.Fatalf("close failed: %s", err)
+	}
+
+	if len(standIn.requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(standIn.requests))
+	}
+	create, close := standIn.requests[1], standIn.requests[2]
+	if create.URL.Path != "/v2/alerts" || create.Header.Get("Authorization") != "GenieKey api-key" {
+		t.Errorf("unexpected create request: %s %s", create.URL.Path, create.Header.Get("Authorization"))
+	}
+	if close.URL.Path != "/v2/alerts/"+alert.DedupKey+"/close" || close.URL.Query().Get("identifierType") != "alias" {
+		t.Errorf("unexpected close request: %s", close.URL)
+	}
+	if standIn.bodies[1]["alias"] != alert.DedupKey {
+		t.Errorf("alias should be the dedup key: %v", standIn.bodies[1]["alias"])
+	}
+}
+
+func Test_certExpiryWarning(t *testing.T) {
+	standIn := &eventsStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	pagerDuty := NewPagerDutyNotifier("routing-key")
+	pagerDuty.url = server.URL
+
+	alert, _ := testEvents()
+	target := &Target{host: "tst-123", url: "https://tst-123/api/Ping",
+		err: &CertificateError{Problem: "expires within 7 days on 2030-01-01", Expiring: true}}
+	warning := newEvent(eventCertExpiry, target, "Certificate warning")
+	if warning.Kind != eventCertExpiry || warning.Severity != severityWarning {
+		t.Errorf("expected a certExpiry warning, got %s %s", warning.Kind, warning.Severity)
+	}
+	if warning.DedupKey == alert.DedupKey {
+		t.Error("the warning should not use the dedup key of the target's incidents")
+	}
+
+	if err := pagerDuty.Notify(warning); err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := standIn.bodies[0]["payload"].(map[string]interface{})
+	if standIn.bodies[0]["dedup_key"] != warning.DedupKey || payload["severity"] != "warning" {
+		t.Errorf("expected a warning under its own dedup key, got %v", standIn.bodies[0])
+	}
+}
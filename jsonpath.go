@@ -0,0 +1,181 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var jsonPathRegex = regexp.MustCompile(`^([^.\[\]]+)?((?:\[\d+\])*)$`)
+var jsonIndexRegex = regexp.MustCompile(`\[(\d+)\]`)
+
+// jsonAssertion compares a value found at a path in a JSON response body.
+// Paths are dot separated keys with optional array indexes, like:
+//   db.status
+//   $.checks[0].state
+// Comparisons are: equals, notEquals, lessThan, greaterThan, exists
+type jsonAssertion struct {
+	name       string // config name, e.g. assert1
+	path       string
+	elements   []interface{} // string keys and int indexes
+	comparison string
+	expected   string
+}
+
+// parseJSONAssertion converts "<path> <comparison> <value>" into a jsonAssertion
+func parseJSONAssertion(name, text string) (Assertion, error) {
+	parts := strings.SplitN(text, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("json assertion must have a path and a comparison: %s", text)
+	}
+	a := &jsonAssertion{name: name, path: parts[0], comparison: parts[1]}
+	if len(parts) > 2 {
+		a.expected = strings.TrimSpace(parts[2])
+	}
+
+	switch a.comparison {
+	case "exists":
+	case "equals", "notEquals":
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("json assertion %q needs a value to compare with", a.comparison)
+		}
+	case "lessThan", "greaterThan":
+		if _, err := strconv.ParseFloat(a.expected, 64); err != nil {
+			return nil, fmt.Errorf("json assertion %q needs a numeric value: %s", a.comparison, a.expected)
+		}
+	default:
+		return nil, fmt.Errorf("unknown json comparison %q (expected equals, notEquals, lessThan, greaterThan, or exists)", a.comparison)
+	}
+
+	elements, err := parseJSONPath(a.path)
+	if err != nil {
+		return nil, err
+	}
+	a.elements = elements
+	return a, nil
+}
+
+// parseJSONPath splits a path like "$.checks[0].state" into its keys and indexes
+func parseJSONPath(path string) ([]interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	elements := []interface{}{}
+	if len(path) == 0 {
+		return elements, nil
+	}
+	for _, part := range strings.Split(path, ".") {
+		match := jsonPathRegex.FindStringSubmatch(part)
+		if match == nil || len(part) == 0 {
+			return nil, fmt.Errorf("invalid json path %q", path)
+		}
+		if len(match[1]) > 0 {
+			elements = append(elements, match[1])
+		}
+		for _, index := range jsonIndexRegex.FindAllStringSubmatch(match[2], -1) {
+			i, _ := strconv.Atoi(index[1])
+			elements = append(elements, i)
+		}
+	}
+	return elements, nil
+}
+
+// lookup walks the decoded JSON document and returns the value at the path
+func (a *jsonAssertion) lookup(doc interface{}) (interface{}, bool) {
+	return jsonLookup(doc, a.elements)
+}
+
+// jsonLookup walks the decoded JSON document and returns the value at the path elements
+func jsonLookup(doc interface{}, elements []interface{}) (interface{}, bool) {
+	value := doc
+	for _, element := range elements {
+		switch e := element.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[e]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok || e >= len(array) {
+				return nil, false
+			}
+			value = array[e]
+		}
+	}
+	return value, true
+}
+
+func (a *jsonAssertion) Check(body []byte) error {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return &AssertionError{Assertion: a, Detail: "response body is not valid JSON: " + err.Error()}
+	}
+
+	value, found := a.lookup(doc)
+	if !found {
+		return &AssertionError{Assertion: a, Detail: "path not found in response body"}
+	}
+	actual := jsonString(value)
+
+	switch a.comparison {
+	case "equals":
+		if !jsonEquals(value, a.expected) {
+			return &AssertionError{Assertion: a, Detail: "actual value was " + actual}
+		}
+	case "notEquals":
+		if jsonEquals(value, a.expected) {
+			return &AssertionError{Assertion: a, Detail: "actual value was " + actual}
+		}
+	case "lessThan", "greaterThan":
+		number, ok := value.(float64)
+		if !ok {
+			return &AssertionError{Assertion: a, Detail: "actual value is not a number: " + actual}
+		}
+		expected, _ := strconv.ParseFloat(a.expected, 64)
+		if (a.comparison == "lessThan" && !(number < expected)) ||
+			(a.comparison == "greaterThan" && !(number > expected)) {
+			return &AssertionError{Assertion: a, Detail: "actual value was " + actual}
+		}
+	}
+	return nil
+}
+
+func (a *jsonAssertion) String() string {
+	if a.comparison == "exists" {
+		return fmt.Sprintf("%s (json %s exists)", a.name, a.path)
+	}
+	return fmt.Sprintf("%s (json %s %s %q)", a.name, a.path, a.comparison, a.expected)
+}
+
+// jsonEquals compares a decoded JSON value with the expected value from the config file
+func jsonEquals(value interface{}, expected string) bool {
+	if number, ok := value.(float64); ok {
+		if expectedNumber, err := strconv.ParseFloat(expected, 64); err == nil {
+			return number == expectedNumber
+		}
+	}
+	return jsonString(value) == expected
+}
+
+// jsonString formats a decoded JSON value for comparisons and alert messages
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return "null"
+	}
+	bytes, _ := json.Marshal(value)
+	return string(bytes)
+}
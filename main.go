@@ -6,14 +6,21 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	flag "github.com/ogier/pflag"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -25,9 +32,14 @@ var (
 	version         = "0.5"
 	verbose         = false
 	maxResponseTime = 60 * time.Second
+	maxDNSTime      = time.Duration(0) // per-phase thresholds, zero means no threshold
+	maxConnectTime  = time.Duration(0)
+	maxTLSTime      = time.Duration(0)
+	maxTTFB         = time.Duration(0)
 	monitorInterval = 3 * time.Minute  // interval between monitoring attempts
-	disableInterval = 60 * time.Minute // monitor disabled for this interval after it alerts
+	disableInterval = 60 * time.Minute // interval between repeat alerts while a target is down
 	logInterval     = 60 * time.Minute // time between stats logging
+	connectTimeout  = time.Duration(0) // time allowed to connect, maxResponseTime when zero
 	mailHost        = ""
 	mailPort        = 25
 	mailUsername    = ""
@@ -35,12 +47,47 @@ var (
 	mailFrom        = ""         // an email address
 	mailTo          = []string{} // a slice of email addresses
 	shellCommand    = ""         // command to run when alert is triggered
+
+	slackWebhookURL = ""
+	slackChannel    = "" // optional, the webhook's channel is used when empty
+	teamsWebhookURL = ""
+
+	pagerDutyRoutingKey = ""
+	pagerDutyURL        = defaultPagerDutyURL
+	opsgenieAPIKey      = ""
+	opsgenieURL         = defaultOpsgenieURL
+
+	recoveryShellCommand = "" // command to run when a down target recovers
+
+	failuresBeforeAlert = 1                // failures within the failure window that trigger an alert
+	failureWindow       = 0                // number of recent checks counted, defaults to failuresBeforeAlert
+	retryInterval       = time.Duration(0) // interval between checks while a target is suspected down
+
+	alertPercentile          = 0.0              // e.g. 95 alerts on the p95 response time, zero means no percentile rule
+	alertPercentileThreshold = 2 * time.Second  // the percentile response time that triggers an alert
+	alertPercentileWindow    = 15 * time.Minute // the response times the percentile is calculated from
+
+	httpListenAddress = "" // address of the embedded HTTP server (dashboard and /metrics), disabled when empty
+	apiToken          = "" // bearer token of the REST API, which is disabled when empty
+	apiStateFile      = "" // where changes made through the REST API are saved, not saved when empty
+
+	historyDirectory       = ""                  // where check history is kept, disabled when empty
+	historyRetention       = 7 * 24 * time.Hour  // how long raw check records are kept
+	historyRollupRetention = 90 * 24 * time.Hour // how long hourly rollups are kept
+
+	configFileName      = ""               // set by the --config flag
+	configCheckInterval = time.Duration(0) // how often the config file is checked for changes, never when zero
+
+	// days before a certificate expires that a warning is sent, largest first
+	certExpiryWarningDays = []int{30, 14, 7, 1}
 )
 
 // This is populated via the config file
 var targets = []Target{}
 
-// Target represents a hostname and a url to be monitored
+// Target represents a hostname and a url to be monitored.
+// The monitor sends copies of its target to the alerts channel, which share the target's slices,
+// so the slices that change with each check (recent and window) are replaced, never changed in place.
 type Target struct {
 	host     string
 	url      string
@@ -48,89 +95,341 @@ type Target struct {
 	password string // http BASIC auth password
 	err      error
 	stats    Stats
+
+	assertions []Assertion // checked against the response body
+
+	expectedStatus    []statusRange // accepted status codes, anything below 400 when empty
+	noFollowRedirects bool          // when true the first response is checked, even if it is a redirect
+	maxRedirects      int           // defaults to defaultMaxRedirects
+	finalURL          string        // when set, the URL the redirects must end on
+
+	peerCert       *x509.Certificate // the first certificate to expire in the last https response
+	certSerial     string            // serial number of the certificate the warning applies to
+	certWarnedDays int               // the smallest expiry threshold already reported
+
+	phases           PhaseTimings  // phase timings of the last request
+	lastResponseTime time.Duration // total time of the last request
+	lastStatus       int           // status code of the last response, zero when there was none
+
+	state      targetState
+	downSince  time.Time     // when the current (or last) outage started
+	lastAlert  time.Time     // when the last alert was sent for the current outage
+	lastOutage time.Duration // the duration of the outage that just ended
+	removed    bool          // set on the copy sent when a down target is no longer monitored
+
+	failuresBeforeAlert int           // overrides the global value when set
+	failureWindow       int           // overrides the global value when set
+	retryInterval       time.Duration // overrides the global value when set
+	recent              []checkResult // the results in the failure window
+
+	maxResponseTime time.Duration // these override the global values when set
+	monitorInterval time.Duration
+	disableInterval time.Duration
+	connectTimeout  time.Duration
+	logInterval     time.Duration
+
+	window []timedSample // the response times for the percentile rule
+
+	method      string            // GET when empty
+	headers     map[string]string // a Host header overrides the host of the request
+	body        []byte
+	contentType string
+
+	steps       []Target     // the requests of a transaction, made in order instead of the target's request
+	extractions []extraction // values a step pulls out of its response for the later steps
+	stepTimings []stepTiming // how long each step of the last transaction took
+
+	jar               *targetJar // created by the first check, and shared with the steps
+	freshCookies      bool       // start every check without cookies
+	resetCookiesEvery int        // empty the jar every N checks, zero means never
+	logCookies        bool       // log the cookies after each check when verbose
+
+	transports  *transportPool // created by the first check, and shared with the steps
+	noKeepAlive bool           // open a new connection for every request
+	connection  connectionOptions
+}
+
+// cookies returns the cookie jar of the target
+func (t *Target) cookies() *targetJar {
+	if t.jar == nil {
+		t.jar = newTargetJar()
+	}
+	return t.jar
 }
 
 // doGet is overridden when testing
-var doGet = func(target Target) error {
+var doGet = func(target *Target) error {
+	target.cookies().startCheck(target.freshCookies, target.resetCookiesEvery)
+	if live().verbose && target.logCookies {
+		defer func() { log.Printf("Cookies of %s: %s", target.host, target.jar) }()
+	}
+	if len(target.steps) > 0 {
+		return doSteps(target)
+	}
+	_, _, err := doRequest(target)
+	return err
+}
 
-	client := NewTimeoutClient(maxResponseTime, maxResponseTime)
+// doRequest times one request for the target and checks the response.
+// It returns the body and headers of the response, which are nil when there was no response.
+func doRequest(target *Target) ([]byte, http.Header, error) {
 
-	req, err := http.NewRequest("GET", target.url, nil)
+	client, err := target.newClient()
 	if err != nil {
-		log.Printf("Error creating GET request: %s: %s", target.url, err)
-		return err
+		log.Printf("Error creating client: %s: %s", target.url, err)
+		return nil, nil, err
+	}
+
+	// Record each status along the way so failures can show how we got there
+	chain := []string{}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if target.noFollowRedirects {
+			return http.ErrUseLastResponse
+		}
+		chain = append(chain, fmt.Sprintf("%d %s", req.Response.StatusCode, via[len(via)-1].URL))
+		maxRedirects := target.maxRedirects
+		if maxRedirects == 0 {
+			maxRedirects = defaultMaxRedirects
+		}
+		if len(via) > maxRedirects {
+			return &StatusError{Reason: fmt.Sprintf("stopped after %d redirects", maxRedirects), Chain: chain}
+		}
+		return nil
 	}
-	if len(target.user) > 0 {
-		req.SetBasicAuth(target.user, target.password)
+
+	req, err := newTargetRequest(target)
+	if err != nil {
+		log.Printf("Error creating request: %s: %s", target.url, err)
+		return nil, nil, err
 	}
+
+	target.lastStatus = 0
+
+	// Time each phase of the request
+	tracer := &phaseTracer{}
+	// The whole request, including reading the body, must finish within the max response time
+	ctx, cancel := context.WithTimeout(context.Background(), target.maxResponse())
+	defer cancel()
+	req = req.WithContext(httptrace.WithClientTrace(ctx, tracer.ClientTrace()))
+	defer func() { target.phases = tracer.Timings() }()
+
 	response, err := client.Do(req)
 	if err != nil {
 		//log.Printf("Error getting URL: %s: %s", target.url, err)
-		return err
+		if urlErr, ok := err.(*url.Error); ok {
+			if statusErr, ok := urlErr.Err.(*StatusError); ok {
+				return nil, nil, statusErr
+			}
+		}
+		return nil, nil, certificateErrorFrom(err)
 	}
 	defer response.Body.Close()
-	if response.StatusCode >= 400 {
-		return errors.New("HTTP Error code: " + response.Status)
+
+	if response.TLS != nil {
+		target.peerCert = earliestExpiring(response.TLS.PeerCertificates)
 	}
+
+	target.lastStatus = response.StatusCode
 	contents, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		log.Printf("Error reading response body: %s", err)
-		return err
+		return nil, response.Header, err
+	}
+
+	finalURL := response.Request.URL.String()
+	chain = append(chain, fmt.Sprintf("%d %s", response.StatusCode, finalURL))
+	if !statusAccepted(target.expectedStatus, !target.noFollowRedirects, response.StatusCode) {
+		return contents, response.Header, &StatusError{Reason: "HTTP Error code: " + response.Status, Chain: chain}
 	}
-	if verbose && false { 
-        // this is too much for verbose... should be verbose+
-		log.Printf("%s\n", string(contents))
+	if len(target.finalURL) > 0 && finalURL != target.finalURL {
+		return contents, response.Header, &StatusError{Reason: "unexpected final URL: " + finalURL, Chain: chain}
 	}
 
-	if verbose {
+	for _, assertion := range target.assertions {
+		if err := assertion.Check(contents); err != nil {
+			return contents, response.Header, err
+		}
+	}
+
+	if err := checkPhaseThresholds(tracer.Timings()); err != nil {
+		return contents, response.Header, err
+	}
+
+	if live().verbose {
 		log.Println("response was within time limit", target.url)
 	}
-	return nil
+	return contents, response.Header, nil
+}
+
+// handleAlert passes a target sent to the alerts channel to the handler for its kind of alert
+func handleAlert(target *Target) {
+	if target.removed {
+		handleRemoved(target)
+	} else if target.err == nil {
+		handleRecovery(target)
+	} else if certErr, ok := target.err.(*CertificateError); ok && certErr.Expiring {
+		handleCertWarning(target)
+	} else {
+		handleSlowResponse(target)
+	}
 }
 
 // handleSlowResponse is overridden when testing
 var handleSlowResponse = func(target *Target) {
-    errorString := target.err.Error()
-   	msg := fmt.Sprintf("Error response from %s: %s, error: %s", target.host, target.url, target.err)
-    if strings.Contains(errorString, "timeout") {
-    	msg = fmt.Sprintf("Slow response from %s: %s, error: %s", target.host, target.url, target.err)
-    }
+	errorString := target.err.Error()
+	msg := alertSubject(target)
 	log.Println(msg)
-
-	var output string
+	event := newEvent(eventAlert, target, msg)
 
 	// Optionally run the shell command specified in the config file
-	if len(shellCommand) > 0 {
-		log.Printf("Executing shell command: %s %s %s\n", shellCommand, target.host)
-		cmd := exec.Command(shellCommand, target.host, target.url, errorString)
-		bytes, err := cmd.CombinedOutput()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error running shell command:", err)
+	output := runShellCommand(shellCommand, target.host, target.url, errorString)
+
+	// Notify configured email addresses (include the output from the shell command)
+	if len(mailHost) > 0 {
+		subject := msg
+		attempts := ""
+		for _, attempt := range target.failedAttempts() {
+			attempts += fmt.Sprintf(" %s: %s \n", attempt.time.Format(time.RFC1123), attempt.err)
 		}
-		output = string(bytes)
-		if verbose {
-			log.Printf("Output:\n %s \n", output)
+		msg = fmt.Sprintf("%s \n\n Failed attempts: \n%s \n Phase timings: %s \n %s \n\n %s", msg, attempts, target.phases, target.stats.String(), output)
+		if stepErr, ok := target.err.(*StepError); ok {
+			msg += fmt.Sprintf("\n\n Step timings: %v \n Response of %s: %s", target.stepTimings, stepErr.Step, stepErr.Excerpt)
+		}
+		err := sendMail(subject, msg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error sending mail:", err)
 		}
 	}
 
+	// Notify the other configured channels (webhooks, etc.)
+	notifyAll(event)
+}
+
+// handleRecovery is overridden when testing
+var handleRecovery = func(target *Target) {
+	outage := target.lastOutage.Truncate(time.Second)
+	msg := fmt.Sprintf("RECOVERED %s: %s, down for %v", target.host, target.url, outage)
+	log.Println(msg)
+	event := newEvent(eventRecovery, target, msg)
+
+	// Optionally run the recovery shell command specified in the config file
+	output := runShellCommand(recoveryShellCommand, target.host, target.url, outage.String())
+
 	// Notify configured email addresses (include the output from the shell command)
 	if len(mailHost) > 0 {
-        subject := msg
-        msg = fmt.Sprintf("%s \n\n %s", msg, output)
+		subject := msg
+		msg = fmt.Sprintf("%s \n\n Outage started: %s \n %s \n\n %s", msg, target.downSince.Format(time.RFC1123), target.stats.String(), output)
 		err := sendMail(subject, msg)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Error sending mail:", err)
 		}
 	}
+
+	// Notify the other configured channels (webhooks, etc.)
+	notifyAll(event)
+}
+
+// handleRemoved is overridden when testing.  A target that was down when it was removed
+// from monitoring won't recover, so its incidents are resolved without running the shell command.
+var handleRemoved = func(target *Target) {
+	outage := target.lastOutage.Truncate(time.Second)
+	msg := fmt.Sprintf("REMOVED %s: %s from monitoring, it was down for %v", target.host, target.url, outage)
+	log.Println(msg)
+	event := newEvent(eventRecovery, target, msg)
+
+	// Notify configured email addresses
+	if len(mailHost) > 0 {
+		err := sendMail(msg, msg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error sending mail:", err)
+		}
+	}
+
+	// Notify the other configured channels (webhooks, etc.)
+	notifyAll(event)
+}
+
+// handleCertWarning is overridden when testing.  The certificate still works,
+// so the target is not down and the shell command is not run.
+var handleCertWarning = func(target *Target) {
+	msg := fmt.Sprintf("Certificate warning for %s: %s, %s", target.host, target.url, target.err)
+	log.Println(msg)
+	event := newEvent(eventCertExpiry, target, msg)
+
+	// Notify configured email addresses
+	if len(mailHost) > 0 {
+		subject := fmt.Sprintf("Certificate of %s expires soon", target.host)
+		err := sendMail(subject, msg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error sending mail:", err)
+		}
+	}
+
+	// Notify the other configured channels (webhooks, etc.)
+	notifyAll(event)
+}
+
+// runShellCommand executes the command (if there is one) and returns its combined output
+func runShellCommand(command string, args ...string) string {
+	if len(command) == 0 {
+		return ""
+	}
+	log.Printf("Executing shell command: %s %s\n", command, strings.Join(args, " "))
+	cmd := exec.Command(command, args...)
+	bytes, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error running shell command:", err)
+	}
+	output := string(bytes)
+	if verbose {
+		log.Printf("Output:\n %s \n", output)
+	}
+	return output
+}
+
+// alertSubject describes the alert in one line, based on the kind of error the target has
+func alertSubject(target *Target) string {
+	kind := "Error response from"
+	err := target.err
+	if stepErr, ok := err.(*StepError); ok {
+		err = stepErr.Err
+	}
+	switch err.(type) {
+	case *AssertionError:
+		kind = "Assertion failed for"
+	case *StatusError:
+		kind = "Unexpected status from"
+	case *CertificateError:
+		kind = "Certificate problem for"
+	default:
+		if isSlow(err) {
+			kind = "Slow response from"
+		}
+	}
+	return fmt.Sprintf("%s %s: %s, error: %s", kind, target.host, target.url, target.err)
+}
+
+// isSlow returns true when the error is caused by a response (or a phase of it) taking too long
+func isSlow(err error) bool {
+	switch e := err.(type) {
+	case *StepError:
+		return isSlow(e.Err)
+	case *PhaseError, *PercentileError:
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timeout")
 }
 
 // processFlags returns true if processing should continue, false otherwise
 func processFlags() bool {
-	var configFileName string
 	var versionFlag bool
 	var helpFlag bool
 	var generateConfig bool
 	var testMail bool
+	var convertConfig bool
+	var checkConfig bool
+	var once bool
+	var format string
 
 	flag.StringVarP(&configFileName, "config", "c", "", "path and name of the config file")
 	flag.BoolVarP(&versionFlag, "version", "V", false, "displays version information")
@@ -138,6 +437,10 @@ func processFlags() bool {
 	flag.BoolVarP(&helpFlag, "help", "?", false, "displays usage help")
 	flag.BoolVarP(&generateConfig, "generate-config", "g", false, "prints a default config file to standard output")
 	flag.BoolVarP(&testMail, "test-mail", "m", false, "sends a test email to the configured mail server")
+	flag.BoolVar(&convertConfig, "convert-config", false, "prints the properties config file in the YAML format")
+	flag.BoolVar(&checkConfig, "check-config", false, "reports the problems in the config file and exits non-zero if there are any")
+	flag.BoolVar(&once, "once", false, "checks the targets once and exits with a Nagios plugin exit code")
+	flag.StringVar(&format, "format", "nagios", "the output format of --once: nagios or json")
 	flag.Parse()
 
 	if versionFlag {
@@ -158,8 +461,37 @@ func processFlags() bool {
 		return false
 	}
 
+	if convertConfig {
+		if len(configFileName) == 0 {
+			fmt.Fprintln(os.Stderr, "Error, --convert-config needs the --config file to convert.")
+		} else if err := convertConfigFile(configFileName); err != nil {
+			fmt.Fprintln(os.Stderr, "Error converting config file "+configFileName+":", err)
+		}
+		return false
+	}
+
+	if checkConfig {
+		if len(configFileName) == 0 {
+			fmt.Fprintln(os.Stderr, "Error, --check-config needs the --config file to check.")
+			os.Exit(1)
+		} else if !checkConfigFile(configFileName) {
+			os.Exit(1)
+		}
+		return false
+	}
+
+	var configErr error
 	if len(configFileName) > 0 {
-		processConfigFile(configFileName)
+		if once {
+			// Keep the output to the results of the checks
+			stdout := os.Stdout
+			os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			configErr = processConfigFile(configFileName)
+			os.Stdout = stdout
+		} else if err := processConfigFile(configFileName); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
 
 	if testMail {
@@ -167,6 +499,18 @@ func processFlags() bool {
 		return false
 	}
 
+	if once {
+		if format != "nagios" && format != "json" {
+			fmt.Fprintln(os.Stderr, "Error, --format must be nagios or json.")
+			os.Exit(nagiosUnknown)
+		}
+		if flag.NArg() > 1 {
+			fmt.Fprintln(os.Stderr, "Error, --once checks one target (a configured host or URL, or any URL) or all of them.")
+			os.Exit(nagiosUnknown)
+		}
+		os.Exit(runOnce(flag.Arg(0), format, configErr))
+	}
+
 	return true
 }
 
@@ -178,24 +522,63 @@ func main() {
 		return
 	}
 
+	if len(historyDirectory) > 0 {
+		store, err := NewFileHistoryStore(historyDirectory, historyRetention, historyRollupRetention)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening check history:", err)
+			os.Exit(1)
+		}
+		history = store
+		go maintainHistory()
+	}
+
+	// Send the notifications in a go-routine, so slow notifiers don't hold up the alerts
+	go sendNotifications(notifications)
+
 	// alertsChan communicates errors back from the monitoring go-routines
 	alertsChan := make(chan *Target)
 
+	// Apply the changes made through the REST API before the last restart
+	monitors = newSupervisor(alertsChan, apiStateFile)
+	configured, err := monitors.loadState(targets)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading API state file:", err)
+	}
+
+	if len(httpListenAddress) > 0 {
+		go serveHTTP(httpListenAddress)
+	}
+
 	// Start each target monitor in a go-routine
 	// When a slow response or an error occurs, a monitor send an alert to the alerts channel
-	for i, target := range targets {
+	for i, target := range configured {
 		if i > 0 {
 			// Spread out the monitors a bit
 			time.Sleep(3 * time.Second)
 		}
-		go monitor(target, alertsChan)
+		if err := monitors.start(target, nil); err != nil {
+			fmt.Fprintln(os.Stderr, "Error starting monitor:", err)
+		}
+	}
+
+	// Reload the config file on a SIGHUP, or when it changes
+	reloads := make(chan os.Signal, 1)
+	if len(configFileName) > 0 {
+		signal.Notify(reloads, syscall.SIGHUP)
+		if configCheckInterval > 0 {
+			go watchConfigFile(configFileName, configCheckInterval, reloads)
+		}
 	}
 
-	// Keep checking the alerts channel for alerts
+	// Keep checking the alerts channel for alerts.  A target without an error has recovered.
 	for {
 		select {
 		case tgt := <-alertsChan:
-			handleSlowResponse(tgt)
+			handleAlert(tgt)
+		case <-reloads:
+			if err := reloadConfig(configFileName); err != nil {
+				log.Println(err)
+			}
 		default:
 			time.Sleep(5 * time.Second)
 		}
@@ -204,39 +587,185 @@ func main() {
 }
 
 // monitor waits for a period of time then times a request for the given URL on a regular basis.
-// If the response is too slow, dump the Java threads and send an email
+// If the response is too slow, dump the Java threads and send an email.
+// When the target is down, it keeps checking at the same interval so it can report the recovery.
 func monitor(target Target, alertsChan chan<- *Target) {
+	monitorWithControl(target, alertsChan, newMonitorControl())
+}
+
+// monitorWithControl is monitor with a control that can stop, pause, or poke it
+func monitorWithControl(target Target, alertsChan chan<- *Target, control *monitorControl) {
 	log.Printf("Monitoring %s: %s\n", target.host, target.url)
+	if target.connection != (connectionOptions{}) {
+		log.Printf("Connection settings of %s: %s\n", target.host, target.connection)
+	}
+	for _, step := range target.steps {
+		if step.connection != (connectionOptions{}) {
+			log.Printf("Connection settings of %s %s: %s\n", target.host, step.host, step.connection)
+		}
+	}
 	target.stats.Clear()
+	defer close(control.done)
+	defer target.transportPool().closeIdle()
 
-	// loop indefinitely
+	// loop until stopped
+	force := false
 	for {
-		target.err = nil
-		t := time.Now()
-
-		// Make the HTTP call
-		err := doGet(target) // doGet sets the err property of target
-
-		// Record the time it took and handle any errors
-		dur := time.Now().Sub(t)
-		target.stats.Add(dur)
-		if time.Now().Sub(target.stats.StartTime) > logInterval {
-			log.Println(target.host, target.stats.String())
-			target.stats.Clear()
+		if force || !control.isPaused() {
+			checkTarget(&target, alertsChan, control)
+
+			// Close the connections of the settings the target no longer uses, after a reload
+			target.transportPool().retain(target.usedTransportSettings())
 		}
 
-		if err != nil {
-			// Let main process know that we've found a slow system
-			target.err = err
-			alertsChan <- &target
-
-			// Don't monitor again for a while
-			time.Sleep(disableInterval)
-		} else {
-			// Wait for the next time we need to monitor
-			time.Sleep(monitorInterval)
+		// Wait for the next time we need to monitor
+		switch control.wait(target.nextInterval()) {
+		case waitStopped:
+			log.Printf("Stopped monitoring %s: %s\n", target.host, target.url)
+			if target.state == stateDown {
+				// The target won't recover now, so let main process resolve its incidents
+				alertsChan <- target.removedCopy()
+			}
+			return
+		case waitCheckNow:
+			force = true
+		case waitUpdated:
+			// Check the new config right away
+			if update := control.takeUpdate(); update != nil && update.url != target.url {
+				moveTarget(&target, update, alertsChan, control)
+			} else if update != nil {
+				target.applyConfig(update)
+			}
+			force = false
+		default:
+			force = false
+		}
+	}
+}
+
+// moveTarget applies a config that changed the URL of the target.  The dashboard and metrics
+// of the old URL are removed, and the incidents about it are resolved, since the alerts of
+// the new URL have another dedup key.
+func moveTarget(target *Target, update *Target, alertsChan chan<- *Target, control *monitorControl) {
+	if target.state == stateDown {
+		control.sendAlert(alertsChan, target.removedCopy())
+		target.lastAlert = time.Time{} // alert about the new URL on its next failed check
+	}
+	control.unlessStopped(func() {
+		dashboard.forget(target)
+		metrics.forget(target)
+	})
+	target.applyConfig(update)
+}
+
+// checkTarget times one request for the target, updates its state, and sends any alert
+func checkTarget(target *Target, alertsChan chan<- *Target, control *monitorControl) {
+	target.err = nil
+	t := time.Now()
+
+	// Make the HTTP call
+	err := doGet(target)
+
+	// Record the time it took and handle any errors
+	dur := time.Now().Sub(t)
+	target.lastResponseTime = dur
+	target.stats.Add(dur)
+	target.stats.AddPhases(target.phases)
+	target.addToWindow(t, dur)
+	if err == nil {
+		err = checkPercentileRule(target)
+	}
+	if time.Now().Sub(target.stats.StartTime) > target.logIntervalValue() {
+		log.Println(target.host, target.stats.String())
+		target.stats.Clear()
+	}
+
+	if err == nil {
+		// A certificate expiry warning is sent without interrupting the monitoring
+		if certErr := checkCertExpiry(target); certErr != nil {
+			warning := *target
+			warning.err = certErr
+			control.sendAlert(alertsChan, &warning)
+		}
+
+		target.recordResult(t, nil)
+		if target.state == stateDown {
+			// Let main process know that the target is back
+			target.lastOutage = time.Now().Sub(target.downSince)
+			target.state = stateUp
+			recovered := *target
+			control.sendAlert(alertsChan, &recovered)
+			target.recent = nil // start counting failures over
 		}
+		target.state = stateUp
+	} else {
+		target.err = err
+		target.recordResult(t, err)
+		failed := target.failedAttempts()
+		if target.state != stateDown && len(failed) >= target.failuresNeeded() {
+			target.state = stateDown
+			target.downSince = failed[0].time
+		}
+
+		// Let main process know that we've found a slow system,
+		// then remind it every disableInterval while the outage lasts
+		if target.state == stateDown &&
+			(target.lastAlert.Before(target.downSince) || time.Now().Sub(target.lastAlert) >= target.disableIntervalValue()) {
+			target.lastAlert = time.Now()
+			alert := *target
+			control.sendAlert(alertsChan, &alert)
+		} else if live().verbose && target.state != stateDown {
+			log.Printf("%s failed %d of the last %d checks: %s\n", target.host, len(failed), len(target.recent), err)
+		}
+	}
+
+	control.unlessStopped(func() {
+		metrics.observe(target, dur, err)
+		dashboard.observe(target, t, dur, err)
+	})
+	recordHistory(target, t, dur, err)
+}
+
+// nextInterval returns how long to wait until the next check (sooner if we suspect the target is down)
+func (t *Target) nextInterval() time.Duration {
+	if t.suspectedDown() && t.retryInterval > 0 {
+		return t.retryInterval
+	} else if t.suspectedDown() && live().retryInterval > 0 {
+		return live().retryInterval
+	}
+	return durationOrDefault(t.monitorInterval, live().monitorInterval)
+}
+
+// maxResponse returns the response time that triggers an alert for the target
+func (t *Target) maxResponse() time.Duration {
+	return durationOrDefault(t.maxResponseTime, live().maxResponseTime)
+}
+
+// connectTimeoutValue returns the time allowed to connect to the target
+func (t *Target) connectTimeoutValue() time.Duration {
+	timeout := durationOrDefault(t.connectTimeout, live().connectTimeout)
+	if timeout == 0 || timeout > t.maxResponse() {
+		return t.maxResponse()
+	}
+	return timeout
+}
+
+// disableIntervalValue returns the time between repeat alerts while the target is down
+func (t *Target) disableIntervalValue() time.Duration {
+	return durationOrDefault(t.disableInterval, live().disableInterval)
+}
+
+// logIntervalValue returns the time between stats logging for the target
+func (t *Target) logIntervalValue() time.Duration {
+	return durationOrDefault(t.logInterval, live().logInterval)
+}
+
+// durationOrDefault returns the value, or the default when the value is zero
+func durationOrDefault(value, defaultValue time.Duration) time.Duration {
+	if value > 0 {
+		return value
 	}
+	return defaultValue
 }
 
 func usage() {
@@ -246,10 +775,17 @@ Program flags are:
   -?, --help            : prints a summary of the arguments accepted by web-mon
   -V, --version         : prints the version of web-mon being run
   -v, --verbose         : prints additional lines to standard output
-  -c, --config          : name and path of config file (required)
+  -c, --config          : name and path of config file (required), a properties file or a .yaml file
   -g, --generate-config : prints an example config file to standard output
-  -m, --test-mail       : sends a test alert email using the configured settings 
-`)
+  -m, --test-mail       : sends a test alert email using the configured settings
+      --convert-config  : prints the properties config file in the YAML format, e.g.
+                          web-mon --convert-config --config my.config > my.yaml
+      --check-config    : reports unknown settings, invalid values, and an unreachable mail
+                          server in the config file, and exits non-zero if there are any
+      --once [target]   : checks every target (or the one with the given host or URL, or any URL)
+                          once, prints a Nagios plugin status line, and exits 0/1/2/3 for
+                          OK/WARNING/CRITICAL/UNKNOWN
+      --format          : the output format of --once, nagios (the default) or json`)
 }
 
 // testMailConfig sends a test email to the configured addresses
@@ -13,9 +13,10 @@ func Test_monitoring(t *testing.T) {
 
 	monitorInterval = 500 * time.Millisecond
 	disableInterval = 3 * time.Second
+	publishSettings()
 
 	// Override the normal doGet function
-	doGet = func(target Target) error {
+	doGet = func(target *Target) error {
 		duration := time.Duration(rand.Float64()*70) * time.Second
 		fmt.Println("test: response time: ", duration)
 		if duration > 60*time.Second {
@@ -0,0 +1,178 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// responseTimeBuckets are the upper bounds (in seconds) of the response time histogram
+var responseTimeBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// checkResults are the result labels of the checks counter
+var checkResults = []string{"success", "slow", "error", "unexpected_status", "assertion_failed", "certificate_error"}
+
+// resultLabel classifies the outcome of a check for the checks counter
+func resultLabel(err error) string {
+	if err == nil {
+		return "success"
+	}
+	if stepErr, ok := err.(*StepError); ok {
+		err = stepErr.Err
+	}
+	switch err.(type) {
+	case *AssertionError:
+		return "assertion_failed"
+	case *StatusError:
+		return "unexpected_status"
+	case *CertificateError:
+		return "certificate_error"
+	}
+	if isSlow(err) {
+		return "slow"
+	}
+	return "error"
+}
+
+// probeMetrics holds the metrics of one target
+type probeMetrics struct {
+	host          string
+	url           string
+	state         targetState
+	lastSuccess   bool
+	lastCheck     time.Time
+	bucketCounts  []uint64 // not cumulative, one per bucket plus +Inf
+	responseSum   float64
+	responseCount uint64
+	results       map[string]uint64
+}
+
+// metricsRegistry holds the metrics of every target, keyed by host and url
+type metricsRegistry struct {
+	mutex   sync.Mutex
+	targets map[string]*probeMetrics
+}
+
+var metrics = &metricsRegistry{targets: map[string]*probeMetrics{}}
+
+// forget removes the metrics of a target that is no longer monitored
+func (r *metricsRegistry) forget(target *Target) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.targets, target.host+"\n"+target.url)
+}
+
+// observe records the outcome of a check
+func (r *metricsRegistry) observe(target *Target, duration time.Duration, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := target.host + "\n" + target.url
+	m, ok := r.targets[key]
+	if !ok {
+		m = &probeMetrics{
+			host:         target.host,
+			url:          target.url,
+			bucketCounts: make([]uint64, len(responseTimeBuckets)+1),
+			results:      map[string]uint64{},
+		}
+		r.targets[key] = m
+	}
+
+	m.state = target.state
+	m.lastSuccess = err == nil
+	m.lastCheck = time.Now()
+	m.results[resultLabel(err)]++
+
+	seconds := duration.Seconds()
+	m.responseSum += seconds
+	m.responseCount++
+	i := sort.SearchFloat64s(responseTimeBuckets, seconds)
+	m.bucketCounts[i]++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (r *metricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := []string{}
+	for key := range r.targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.writeGauge(w, keys, "webmon_up", "Whether the target is up (1), down (0) or not known yet (-1).", func(m *probeMetrics) float64 {
+		switch m.state {
+		case stateUp:
+			return 1
+		case stateDown:
+			return 0
+		}
+		return -1
+	})
+	r.writeGauge(w, keys, "webmon_last_check_success", "Whether the last check of the target succeeded.", func(m *probeMetrics) float64 {
+		if m.lastSuccess {
+			return 1
+		}
+		return 0
+	})
+	r.writeGauge(w, keys, "webmon_last_check_timestamp_seconds", "Unix time of the last check of the target.", func(m *probeMetrics) float64 {
+		return float64(m.lastCheck.UnixNano()) / 1e9
+	})
+
+	fmt.Fprintln(w, "# HELP webmon_checks_total Number of checks of the target by result.")
+	fmt.Fprintln(w, "# TYPE webmon_checks_total counter")
+	for _, key := range keys {
+		m := r.targets[key]
+		for _, result := range checkResults {
+			fmt.Fprintf(w, "webmon_checks_total{%s,result=%q} %d\n", m.labels(), result, m.results[result])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP webmon_response_time_seconds Response time of the checks of the target.")
+	fmt.Fprintln(w, "# TYPE webmon_response_time_seconds histogram")
+	for _, key := range keys {
+		m := r.targets[key]
+		var cumulative uint64
+		for i, bound := range responseTimeBuckets {
+			cumulative += m.bucketCounts[i]
+			fmt.Fprintf(w, "webmon_response_time_seconds_bucket{%s,le=\"%g\"} %d\n", m.labels(), bound, cumulative)
+		}
+		cumulative += m.bucketCounts[len(responseTimeBuckets)]
+		fmt.Fprintf(w, "webmon_response_time_seconds_bucket{%s,le=\"+Inf\"} %d\n", m.labels(), cumulative)
+		fmt.Fprintf(w, "webmon_response_time_seconds_sum{%s} %g\n", m.labels(), m.responseSum)
+		fmt.Fprintf(w, "webmon_response_time_seconds_count{%s} %d\n", m.labels(), m.responseCount)
+	}
+}
+
+func (r *metricsRegistry) writeGauge(w io.Writer, keys []string, name, help string, value func(*probeMetrics) float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	for _, key := range keys {
+		m := r.targets[key]
+		fmt.Fprintf(w, "%s{%s} %g\n", name, m.labels(), value(m))
+	}
+}
+
+// labels returns the host and url labels of the target's metrics
+func (m *probeMetrics) labels() string {
+	return fmt.Sprintf("host=\"%s\",url=\"%s\"", escapeLabel(m.host), escapeLabel(m.url))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value as required by the exposition format
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
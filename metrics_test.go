@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_metricsExposition(t *testing.T) {
+	registry := &metricsRegistry{targets: map[string]*probeMetrics{}}
+	target := &Target{host: `shop "eu"`, url: "https://shop.example.com/a\\b\nc", state: stateUp}
+	for _, ms := range []int{30, 300, 300} {
+		registry.observe(target, time.Duration(ms)*time.Millisecond, nil)
+	}
+	target.state = stateDown
+	registry.observe(target, 90*time.Second, errors.New("dial tcp: i/o timeout"))
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	output := w.Body.String()
+
+	labels := `host="shop \"eu\"",url="https://shop.example.com/a\\b\nc"`
+	for _, line := range []string{
+		"# TYPE webmon_up gauge",
+		"webmon_up{" + labels + "} 0",
+		"webmon_last_check_success{" + labels + "} 0",
+		"webmon_checks_total{" + labels + `,result="success"} 3`,
+		"webmon_checks_total{" + labels + `,result="slow"} 1`,
+		"webmon_checks_total{" + labels + `,result="error"} 0`,
+		"# TYPE webmon_response_time_seconds histogram",
+		"webmon_response_time_seconds_bucket{" + labels + `,le="0.05"} 1`,
+		"webmon_response_time_seconds_bucket{" + labels + `,le="0.25"} 1`,
+		"webmon_response_time_seconds_bucket{" + labels + `,le="0.5"} 3`,
+		"webmon_response_time_seconds_bucket{" + labels + `,le="60"} 3`,
+		"webmon_response_time_seconds_bucket{" + labels + `,le="+Inf"} 4`,
+		"webmon_response_time_seconds_sum{" + labels + "} 90.63",
+		"webmon_response_time_seconds_count{" + labels + "} 4",
+	} {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("expected the line %s in:\n%s", line, output)
+		}
+	}
+
+	// A target that is no longer monitored has no metrics
+	registry.forget(target)
+	w = httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(w.Body.String(), "shop") {
+		t.Errorf("expected the metrics of the target to be removed:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,131 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	eventAlert      = "alert"
+	eventRecovery   = "recovery"
+	eventCertExpiry = "certExpiry"
+)
+
+// Event severities, used by the chat notifiers to pick a color
+const (
+	severityDown      = "down"
+	severitySlow      = "slow"
+	severityRecovered = "recovered"
+	severityWarning   = "warning"
+)
+
+// Event describes an alert or a recovery to the notifiers.
+// The fields are exported so they can be used in payload templates.
+type Event struct {
+	Kind         string // alert, recovery, or certExpiry
+	Severity     string // down, slow, recovered, or warning
+	Subject      string // the one line summary used in emails and logs
+	Host         string
+	URL          string
+	DedupKey     string // stable per target, used to resolve incidents
+	Error        string
+	State        string
+	Time         time.Time
+	Outage       time.Duration // how long the target was down (recovery only)
+	ResponseTime time.Duration // the time taken by the last check
+	MaxResponse  time.Duration // the configured maxResponseTime of the target
+	Phases       PhaseTimings
+	Stats        Stats
+}
+
+// newEvent builds the event for a target that was sent to the alerts channel
+func newEvent(kind string, target *Target, subject string) *Event {
+	event := &Event{
+		Kind:         kind,
+		Subject:      subject,
+		Host:         target.host,
+		URL:          target.url,
+		DedupKey:     dedupKey(target.host, target.url),
+		State:        target.state.String(),
+		Time:         time.Now(),
+		ResponseTime: target.lastResponseTime,
+		MaxResponse:  target.maxResponse(),
+		Phases:       target.phases,
+		Stats:        target.stats,
+	}
+	event.Severity = severityDown
+	if target.err != nil {
+		event.Error = target.err.Error()
+		if isSlow(target.err) {
+			event.Severity = severitySlow
+		}
+	}
+	if kind == eventRecovery {
+		event.Severity = severityRecovered
+		event.Outage = target.lastOutage
+	}
+
+	// An expiring certificate still works, so its warning is kept apart from the incidents
+	// about the target being down or slow, which a recovery resolves
+	if kind == eventCertExpiry {
+		event.Severity = severityWarning
+		event.DedupKey += "-cert"
+	}
+	return event
+}
+
+// Notifier sends alert and recovery events to an external service
+type Notifier interface {
+	Name() string
+	Notify(event *Event) error
+}
+
+// This is populated via the config file
+var notifiers = []Notifier{}
+
+// notification is an event queued for the notifiers configured when it happened
+type notification struct {
+	event     *Event
+	notifiers []Notifier
+}
+
+// notifications is the queue of the notification worker.  The notifiers retry failed requests
+// with a backoff, so they are called outside the main loop, which keeps handling alerts and reloads.
+var notifications = make(chan notification, 100)
+
+// notifyAll queues the event for every configured notifier.  When the queue is full, the event is dropped and reported.
+func notifyAll(event *Event) {
+	if len(notifiers) == 0 {
+		return
+	}
+	select {
+	case notifications <- notification{event: event, notifiers: notifiers}:
+	default:
+		fmt.Fprintf(os.Stderr, "Error sending %s notification of %s: too many notifications are waiting\n", event.Kind, event.Host)
+	}
+}
+
+// sendNotifications is the notification worker.  It sends the queued events in order, until the queue is closed.
+func sendNotifications(queue <-chan notification) {
+	for next := range queue {
+		next.send()
+	}
+}
+
+// send passes the event to each notifier.  Errors are reported but don't stop the others.
+func (n notification) send() {
+	for _, notifier := range n.notifiers {
+		if live().verbose {
+			fmt.Printf("Sending %s notification to %s\n", n.event.Kind, notifier.Name())
+		}
+		if err := notifier.Notify(n.event); err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending %s notification: %s\n", notifier.Name(), err)
+		}
+	}
+}
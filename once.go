@@ -0,0 +1,204 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The exit codes and status names of a Nagios plugin
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosStatus = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosSeverity ranks the exit codes from best to worst
+var nagiosSeverity = map[int]int{nagiosOK: 0, nagiosUnknown: 1, nagiosWarning: 2, nagiosCritical: 3}
+
+// onceResult is the result of checking one target in --once mode
+type onceResult struct {
+	Host         string             `json:"host"`
+	URL          string             `json:"url"`
+	Status       string             `json:"status"`
+	ExitCode     int                `json:"exitCode"`
+	ResponseTime float64            `json:"responseTimeSeconds"`
+	MaxResponse  float64            `json:"maxResponseTimeSeconds"`
+	HTTPStatus   int                `json:"httpStatus,omitempty"`
+	Phases       map[string]float64 `json:"phasesSeconds,omitempty"`
+	Message      string             `json:"message"`
+}
+
+// runOnce checks the configured targets (or the one named by the argument) once, at the same time,
+// and prints the results in the format (nagios or json).  It returns the Nagios exit code of the worst result.
+// When the config file could not be read, the result is UNKNOWN.
+func runOnce(arg string, format string, configErr error) int {
+	checked, err := onceTargets(targets, arg)
+	if configErr != nil {
+		err = configErr
+	}
+	results := []onceResult{}
+	if err == nil {
+		results = checkOnce(checked)
+	}
+
+	exitCode := nagiosOK
+	if err != nil {
+		exitCode = nagiosUnknown
+	}
+	for _, result := range results {
+		if nagiosSeverity[result.ExitCode] > nagiosSeverity[exitCode] {
+			exitCode = result.ExitCode
+		}
+	}
+
+	if format == "json" {
+		output := struct {
+			Status   string       `json:"status"`
+			ExitCode int          `json:"exitCode"`
+			Error    string       `json:"error,omitempty"`
+			Targets  []onceResult `json:"targets"`
+		}{Status: nagiosStatus[exitCode], ExitCode: exitCode, Targets: results}
+		if err != nil {
+			output.Error = err.Error()
+		}
+		bytes, _ := json.MarshalIndent(output, "", "  ")
+		fmt.Println(string(bytes))
+	} else {
+		fmt.Print(nagiosOutput(exitCode, results, err))
+	}
+	return exitCode
+}
+
+// onceTargets returns the targets to check: all of them, the ones whose host or URL is the argument,
+// or a new target when the argument is a URL that is not configured
+func onceTargets(configured []Target, arg string) ([]Target, error) {
+	if len(arg) == 0 {
+		if len(configured) == 0 {
+			return nil, fmt.Errorf("no targets are configured")
+		}
+		return configured, nil
+	}
+	matched := []Target{}
+	for _, target := range configured {
+		if target.host == arg || target.url == arg {
+			matched = append(matched, target)
+		}
+	}
+	if len(matched) > 0 {
+		return matched, nil
+	}
+	if err := validateTargetURL(arg); err != nil {
+		return nil, fmt.Errorf("%s is not a configured target or a URL", arg)
+	}
+	u, _ := url.Parse(arg)
+	return []Target{{host: u.Hostname(), url: arg}}, nil
+}
+
+// checkOnce checks each target at the same time, and returns the results in the same order
+func checkOnce(checked []Target) []onceResult {
+	results := make([]onceResult, len(checked))
+	var wg sync.WaitGroup
+	for i := range checked {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = checkTargetOnce(&checked[i])
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// checkTargetOnce times one request for the target.  A slow response or an expiring
+// certificate is a warning, and any other failure is critical.
+func checkTargetOnce(target *Target) onceResult {
+	t := time.Now()
+	err := doGet(target)
+	dur := time.Now().Sub(t)
+
+	result := onceResult{
+		Host:         target.host,
+		URL:          target.url,
+		ExitCode:     nagiosOK,
+		ResponseTime: dur.Seconds(),
+		MaxResponse:  target.maxResponse().Seconds(),
+		HTTPStatus:   target.lastStatus,
+		Message:      fmt.Sprintf("responded in %v", dur.Truncate(time.Millisecond)),
+	}
+	if target.lastStatus > 0 {
+		result.Message += fmt.Sprintf(" with status %d", target.lastStatus)
+	}
+	if target.phases != (PhaseTimings{}) {
+		result.Phases = map[string]float64{
+			"dns":     target.phases.DNS.Seconds(),
+			"connect": target.phases.Connect.Seconds(),
+			"tls":     target.phases.TLS.Seconds(),
+			"ttfb":    target.phases.TTFB.Seconds(),
+		}
+	}
+
+	expiring := false
+	if err == nil {
+		err = checkCertExpiry(target)
+		expiring = err != nil
+	}
+	if err != nil {
+		result.ExitCode = nagiosCritical
+		if expiring || isSlow(err) {
+			result.ExitCode = nagiosWarning
+		}
+		result.Message = err.Error()
+	}
+	result.Status = nagiosStatus[result.ExitCode]
+	return result
+}
+
+// nagiosOutput returns the plugin output: a status line with the performance data,
+// followed by a line for each target when there is more than one
+func nagiosOutput(exitCode int, results []onceResult, err error) string {
+	if err != nil {
+		return fmt.Sprintf("WEB-MON %s - %s\n", nagiosStatus[exitCode], err)
+	}
+
+	perfdata := []string{}
+	for _, result := range results {
+		perfdata = append(perfdata, fmt.Sprintf("'%s'=%.3fs;%.3f;;0;", perfLabel(result), result.ResponseTime, result.MaxResponse))
+	}
+	if len(results) == 1 {
+		result := results[0]
+		return fmt.Sprintf("WEB-MON %s - %s: %s %s | %s\n", result.Status, result.Host, result.URL, result.Message, strings.Join(perfdata, " "))
+	}
+
+	counts := map[int]int{}
+	for _, result := range results {
+		counts[result.ExitCode]++
+	}
+	summary := fmt.Sprintf("%d of %d targets OK", counts[nagiosOK], len(results))
+	for _, code := range []int{nagiosCritical, nagiosWarning, nagiosUnknown} {
+		if counts[code] > 0 {
+			summary += fmt.Sprintf(", %d %s", counts[code], nagiosStatus[code])
+		}
+	}
+	lines := []string{fmt.Sprintf("WEB-MON %s - %s | %s", nagiosStatus[exitCode], summary, strings.Join(perfdata, " "))}
+	for _, result := range results {
+		lines = append(lines, fmt.Sprintf("%s - %s: %s %s", result.Status, result.Host, result.URL, result.Message))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// perfLabel returns the performance data label of a result, which can't have quotes or equal signs
+func perfLabel(result onceResult) string {
+	return strings.NewReplacer("'", "", "=", "_").Replace(result.Host + " " + result.URL)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_checkOnce(t *testing.T) {
+	savedGet := doGet
+	defer func() { doGet = savedGet }()
+	doGet = func(target *Target) error {
+		switch target.host {
+		case "slow":
+			return errors.New("net/http: timeout awaiting response headers")
+		case "down":
+			return &StatusError{Reason: "HTTP Error code: 503"}
+		}
+		target.lastStatus = 200
+		return nil
+	}
+	configured := []Target{
+		{host: "up", url: "https://up.example.com"},
+		{host: "slow", url: "https://slow.example.com"},
+		{host: "down", url: "https://down.example.com"},
+	}
+
+	results := checkOnce(configured)
+	for i, expected := range []int{nagiosOK, nagiosWarning, nagiosCritical} {
+		if results[i].ExitCode != expected {
+			t.Errorf("expected %s to be %s, got %s", results[i].Host, nagiosStatus[expected], results[i].Status)
+		}
+	}
+	output := nagiosOutput(nagiosCritical, results, nil)
+	if !strings.HasPrefix(output, "WEB-MON CRITICAL - 1 of 3 targets OK, 1 CRITICAL, 1 WARNING | 'up https://up.example.com'=") {
+		t.Errorf("unexpected status line: %s", output)
+	}
+	// The max response time is the warning threshold, since a slow response is a warning
+	if !strings.Contains(output, "s;60.000;;0; 'slow https://slow.example.com'=") {
+		t.Errorf("expected the max response time as the warning threshold: %s", output)
+	}
+	if lines := strings.Split(strings.TrimSpace(output), "\n"); len(lines) != 4 {
+		t.Errorf("expected a line for each target, got %d lines", len(lines))
+	}
+
+	checked, err := onceTargets(configured, "down")
+	if err != nil || len(checked) != 1 || checked[0].url != "https://down.example.com" {
+		t.Errorf("expected the target named down, got %v %v", checked, err)
+	}
+	checked, err = onceTargets(configured, "http://other.example.com/ping")
+	if err != nil || len(checked) != 1 || checked[0].host != "other.example.com" {
+		t.Errorf("expected a new target for the URL, got %v %v", checked, err)
+	}
+	if _, err = onceTargets(configured, "nothing"); err == nil {
+		t.Error("expected an error for an argument that is not a target or a URL")
+	}
+}
@@ -0,0 +1,340 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// settings is a copy of the global variables set by the config file
+type settings struct {
+	verbose                  bool
+	maxResponseTime          time.Duration
+	maxDNSTime               time.Duration
+	maxConnectTime           time.Duration
+	maxTLSTime               time.Duration
+	maxTTFB                  time.Duration
+	monitorInterval          time.Duration
+	disableInterval          time.Duration
+	logInterval              time.Duration
+	connectTimeout           time.Duration
+	mailHost                 string
+	mailPort                 int
+	mailUsername             string
+	mailPassword             string
+	mailFrom                 string
+	mailTo                   []string
+	shellCommand             string
+	slackWebhookURL          string
+	slackChannel             string
+	teamsWebhookURL          string
+	pagerDutyRoutingKey      string
+	pagerDutyURL             string
+	opsgenieAPIKey           string
+	opsgenieURL              string
+	recoveryShellCommand     string
+	failuresBeforeAlert      int
+	failureWindow            int
+	retryInterval            time.Duration
+	alertPercentile          float64
+	alertPercentileThreshold time.Duration
+	alertPercentileWindow    time.Duration
+	httpListenAddress        string
+	apiToken                 string
+	apiStateFile             string
+	historyDirectory         string
+	historyRetention         time.Duration
+	historyRollupRetention   time.Duration
+	configCheckInterval      time.Duration
+	certExpiryWarningDays    []int
+	targets                  []Target
+	notifiers                []Notifier
+}
+
+// The settings before the config file is read, which a reload starts from
+var defaultSettings = currentSettings()
+
+// published holds the settings the monitor go-routines read.  The config file is processed into
+// the global variables on the main go-routine, which are then published in one step, so a check
+// never sees the defaults a reload starts from, or half of a new config.
+var published struct {
+	sync.RWMutex
+	settings *settings
+}
+
+// publishSettings makes the global variables the settings that the monitors read
+func publishSettings() {
+	s := currentSettings()
+	published.Lock()
+	published.settings = &s
+	published.Unlock()
+}
+
+// live returns the settings published last (the defaults before the config file is read)
+func live() *settings {
+	published.RLock()
+	defer published.RUnlock()
+	if published.settings == nil {
+		return &defaultSettings
+	}
+	return published.settings
+}
+
+func currentSettings() settings {
+	return settings{
+		verbose:                  verbose,
+		maxResponseTime:          maxResponseTime,
+		maxDNSTime:               maxDNSTime,
+		maxConnectTime:           maxConnectTime,
+		maxTLSTime:               maxTLSTime,
+		maxTTFB:                  maxTTFB,
+		monitorInterval:          monitorInterval,
+		disableInterval:          disableInterval,
+		logInterval:              logInterval,
+		connectTimeout:           connectTimeout,
+		mailHost:                 mailHost,
+		mailPort:                 mailPort,
+		mailUsername:             mailUsername,
+		mailPassword:             mailPassword,
+		mailFrom:                 mailFrom,
+		mailTo:                   mailTo,
+		shellCommand:             shellCommand,
+		slackWebhookURL:          slackWebhookURL,
+		slackChannel:             slackChannel,
+		teamsWebhookURL:          teamsWebhookURL,
+		pagerDutyRoutingKey:      pagerDutyRoutingKey,
+		pagerDutyURL:             pagerDutyURL,
+		opsgenieAPIKey:           opsgenieAPIKey,
+		opsgenieURL:              opsgenieURL,
+		recoveryShellCommand:     recoveryShellCommand,
+		failuresBeforeAlert:      failuresBeforeAlert,
+		failureWindow:            failureWindow,
+		retryInterval:            retryInterval,
+		alertPercentile:          alertPercentile,
+		alertPercentileThreshold: alertPercentileThreshold,
+		alertPercentileWindow:    alertPercentileWindow,
+		httpListenAddress:        httpListenAddress,
+		apiToken:                 apiToken,
+		apiStateFile:             apiStateFile,
+		historyDirectory:         historyDirectory,
+		historyRetention:         historyRetention,
+		historyRollupRetention:   historyRollupRetention,
+		configCheckInterval:      configCheckInterval,
+		certExpiryWarningDays:    certExpiryWarningDays,
+		targets:                  targets,
+		notifiers:                notifiers,
+	}
+}
+
+// restore assigns the settings back to the global variables
+func (s settings) restore() {
+	verbose = s.verbose
+	maxResponseTime = s.maxResponseTime
+	maxDNSTime = s.maxDNSTime
+	maxConnectTime = s.maxConnectTime
+	maxTLSTime = s.maxTLSTime
+	maxTTFB = s.maxTTFB
+	monitorInterval = s.monitorInterval
+	disableInterval = s.disableInterval
+	logInterval = s.logInterval
+	connectTimeout = s.connectTimeout
+	mailHost = s.mailHost
+	mailPort = s.mailPort
+	mailUsername = s.mailUsername
+	mailPassword = s.mailPassword
+	mailFrom = s.mailFrom
+	mailTo = s.mailTo
+	shellCommand = s.shellCommand
+	slackWebhookURL = s.slackWebhookURL
+	slackChannel = s.slackChannel
+	teamsWebhookURL = s.teamsWebhookURL
+	pagerDutyRoutingKey = s.pagerDutyRoutingKey
+	pagerDutyURL = s.pagerDutyURL
+	opsgenieAPIKey = s.opsgenieAPIKey
+	opsgenieURL = s.opsgenieURL
+	recoveryShellCommand = s.recoveryShellCommand
+	failuresBeforeAlert = s.failuresBeforeAlert
+	failureWindow = s.failureWindow
+	retryInterval = s.retryInterval
+	alertPercentile = s.alertPercentile
+	alertPercentileThreshold = s.alertPercentileThreshold
+	alertPercentileWindow = s.alertPercentileWindow
+	httpListenAddress = s.httpListenAddress
+	apiToken = s.apiToken
+	apiStateFile = s.apiStateFile
+	historyDirectory = s.historyDirectory
+	historyRetention = s.historyRetention
+	historyRollupRetention = s.historyRollupRetention
+	configCheckInterval = s.configCheckInterval
+	certExpiryWarningDays = s.certExpiryWarningDays
+	targets = s.targets
+	notifiers = s.notifiers
+}
+
+// keepStartupSettings puts back the settings that are only read when web-mon starts,
+// and logs the ones that changed in the config file
+func (s settings) keepStartupSettings() {
+	startup := []struct {
+		name    string
+		value   *string
+		running string
+	}{
+		{"httpListenAddress", &httpListenAddress, s.httpListenAddress},
+		{"apiToken", &apiToken, s.apiToken},
+		{"apiStateFile", &apiStateFile, s.apiStateFile},
+		{"historyDirectory", &historyDirectory, s.historyDirectory},
+	}
+	for _, setting := range startup {
+		if *setting.value != setting.running {
+			log.Printf("%s changed, restart web-mon to apply it\n", setting.name)
+			*setting.value = setting.running
+		}
+	}
+	if configCheckInterval != s.configCheckInterval {
+		log.Println("configCheckIntervalInSeconds changed, restart web-mon to apply it")
+		configCheckInterval = s.configCheckInterval
+	}
+}
+
+// reloadConfig reads the config file again and applies it to the running monitors.
+// An invalid config file is rejected and the running config is kept.
+func reloadConfig(fileName string) error {
+	log.Println("Reloading config file:", fileName)
+	props, err := _readConfigFile(fileName)
+	if err != nil {
+		return fmt.Errorf("config reload rejected, keeping the running config: %s", err)
+	}
+
+	// Settings left out of the config file go back to their default values
+	running := currentSettings()
+	reset := defaultSettings
+	reset.verbose = verbose // which may be set by a program flag
+	reset.restore()
+
+	if problems := _processConfig(props); len(problems) > 0 {
+		running.restore()
+		for _, problem := range problems {
+			log.Println("Config problem:", problem)
+		}
+		return fmt.Errorf("config reload rejected (%d problems), keeping the running config", len(problems))
+	}
+	running.keepStartupSettings()
+	publishSettings()
+
+	monitors.reload(targets)
+
+	// A paused target whose URL changed is saved with its new id
+	if err := monitors.saveState(); err != nil {
+		log.Println("Error saving the API state file:", err)
+	}
+	log.Println("Config reloaded")
+	return nil
+}
+
+// watchConfigFile asks for a reload whenever the modification time of the config file changes
+func watchConfigFile(fileName string, interval time.Duration, reloads chan<- os.Signal) {
+	var modTime time.Time
+	if info, err := os.Stat(fileName); err == nil {
+		modTime = info.ModTime()
+	}
+	for range time.Tick(interval) {
+		info, err := os.Stat(fileName)
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+		select {
+		case reloads <- syscall.SIGHUP:
+		default: // a reload is already waiting
+		}
+	}
+}
+
+// sameConfig returns true when the configured settings of the targets are the same
+func sameConfig(a, b *Target) bool {
+	return a.user == b.user &&
+		a.password == b.password &&
+		fmt.Sprint(a.assertions) == fmt.Sprint(b.assertions) &&
+		fmt.Sprint(a.expectedStatus) == fmt.Sprint(b.expectedStatus) &&
+		a.noFollowRedirects == b.noFollowRedirects &&
+		a.maxRedirects == b.maxRedirects &&
+		a.finalURL == b.finalURL &&
+		a.failuresBeforeAlert == b.failuresBeforeAlert &&
+		a.failureWindow == b.failureWindow &&
+		a.retryInterval == b.retryInterval &&
+		a.maxResponseTime == b.maxResponseTime &&
+		a.monitorInterval == b.monitorInterval &&
+		a.disableInterval == b.disableInterval &&
+		a.connectTimeout == b.connectTimeout &&
+		a.logInterval == b.logInterval &&
+		a.method == b.method &&
+		fmt.Sprint(a.headers) == fmt.Sprint(b.headers) &&
+		string(a.body) == string(b.body) &&
+		a.contentType == b.contentType &&
+		a.freshCookies == b.freshCookies &&
+		a.resetCookiesEvery == b.resetCookiesEvery &&
+		a.logCookies == b.logCookies &&
+		a.noKeepAlive == b.noKeepAlive &&
+		a.connection == b.connection &&
+		sameSteps(a.steps, b.steps)
+}
+
+// sameSteps returns true when both transactions have the same steps, in the same order
+func sameSteps(a, b []Target) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].url != b[i].url || !sameConfig(&a[i], &b[i]) {
+			return false
+		}
+		if len(a[i].extractions) != len(b[i].extractions) {
+			return false
+		}
+		for j, e := range a[i].extractions {
+			other := b[i].extractions[j]
+			if e.variable != other.variable || e.kind != other.kind || e.text != other.text {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyConfig copies the URL and configured settings of the other target, keeping the stats, cookies, and alert state
+func (t *Target) applyConfig(other *Target) {
+	t.url = other.url
+	t.user = other.user
+	t.password = other.password
+	t.assertions = other.assertions
+	t.expectedStatus = other.expectedStatus
+	t.noFollowRedirects = other.noFollowRedirects
+	t.maxRedirects = other.maxRedirects
+	t.finalURL = other.finalURL
+	t.failuresBeforeAlert = other.failuresBeforeAlert
+	t.failureWindow = other.failureWindow
+	t.retryInterval = other.retryInterval
+	t.maxResponseTime = other.maxResponseTime
+	t.monitorInterval = other.monitorInterval
+	t.disableInterval = other.disableInterval
+	t.connectTimeout = other.connectTimeout
+	t.logInterval = other.logInterval
+	t.method = other.method
+	t.headers = other.headers
+	t.body = other.body
+	t.contentType = other.contentType
+	t.steps = other.steps
+	t.freshCookies = other.freshCookies
+	t.resetCookiesEvery = other.resetCookiesEvery
+	t.logCookies = other.logCookies
+	t.noKeepAlive = other.noKeepAlive
+	t.connection = other.connection
+}
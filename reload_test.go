@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_reloadConfig(t *testing.T) {
+	savedGet := doGet
+	checks := make(chan Target, 100)
+	doGet = func(target *Target) error {
+		select {
+		case checks <- *target:
+		default:
+		}
+		return nil
+	}
+
+	// waitForCheck waits for the running monitor to check a target that matches
+	waitForCheck := func(matches func(target *Target) bool, description string) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case target := <-checks:
+				if matches(&target) {
+					return
+				}
+			case <-timeout:
+				t.Fatal("expected the monitor to check", description)
+			}
+		}
+	}
+	dir, err := ioutil.TempDir("", "web-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "web-mon.config")
+	writeConfig := func(text string) {
+		if err := ioutil.WriteFile(fileName, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig(`
+maxResponseTimeInSeconds = 10
+monitor.target1 = one, https://one.example.com/ping
+monitor.target2 = two, https://two.example.com/ping
+`)
+	if err := processConfigFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	monitors = newSupervisor(make(chan *Target, 10), "")
+	for _, target := range targets {
+		monitors.start(target, nil)
+	}
+	defer func() {
+		stopMonitors()
+		doGet = savedGet
+		defaultSettings.restore()
+		publishSettings()
+	}()
+	one := monitors.list()[0]
+
+	// An invalid config is rejected and the running config is kept
+	writeConfig(`
+maxResponseTimeInSeconds = 20
+monitor.target1 = one, https://one.example.com/ping
+monitor.target1.expectedStatus = ok
+`)
+	if err := reloadConfig(fileName); err == nil {
+		t.Error("expected the invalid config to be rejected")
+	}
+	if maxResponseTime != 10*time.Second || len(targets) != 2 || len(monitors.list()) != 2 {
+		t.Errorf("expected the running config to be kept, got %s and %d targets", maxResponseTime, len(targets))
+	}
+
+	// A valid config changes one target in place, removes one, and adds one
+	writeConfig(`
+monitor.target1 = one, https://one.example.com/ping
+monitor.target1.expectedStatus = 200
+monitor.target2 = three, https://three.example.com/ping
+`)
+	if err := reloadConfig(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if maxResponseTime != 60*time.Second {
+		t.Errorf("expected maxResponseTime to go back to its default, got %s", maxResponseTime)
+	}
+	list := monitors.list()
+	if len(list) != 2 || list[0].target.host != "one" || list[1].target.host != "three" {
+		t.Fatalf("expected targets one and three, got %d targets", len(list))
+	}
+	if list[0] != one || len(list[0].target.expectedStatus) != 1 {
+		t.Error("expected target one to be updated in place")
+	}
+	waitForCheck(func(target *Target) bool {
+		return target.host == "one" && len(target.expectedStatus) == 1
+	}, "target one with its new expected status")
+
+	// So is a new URL of a target, which is found by its host
+	writeConfig(`
+monitor.target1 = one, https://one.example.com/health
+monitor.target1.expectedStatus = 200
+monitor.target2 = three, https://three.example.com/ping
+`)
+	if err := reloadConfig(fileName); err != nil {
+		t.Fatal(err)
+	}
+	list = monitors.list()
+	if len(list) != 2 || list[0] != one || list[0].target.url != "https://one.example.com/health" {
+		t.Fatal("expected the URL of target one to be changed in place")
+	}
+	if control, err := monitors.control(targetID(&list[0].target)); err != nil || control != one.control {
+		t.Errorf("expected target one to have the id of its new URL, got %v", err)
+	}
+	waitForCheck(func(target *Target) bool {
+		return target.url == "https://one.example.com/health" && target.stats.SampleCount >= 2
+	}, "the new URL of target one, keeping its stats")
+}
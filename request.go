@@ -0,0 +1,126 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// httpMethods are the methods a target's request can use
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// newTargetRequest creates the request for the target, with its method, headers and body.
+// A Host header overrides the host the request is sent to.
+func newTargetRequest(target *Target) (*http.Request, error) {
+	method := target.method
+	if len(method) == 0 {
+		method = "GET"
+	}
+	var body io.Reader
+	if len(target.body) > 0 {
+		body = bytes.NewReader(target.body)
+	}
+	req, err := http.NewRequest(method, target.url, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range target.headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+	if len(target.contentType) > 0 {
+		req.Header.Set("Content-Type", target.contentType)
+	}
+	if len(target.user) > 0 {
+		req.SetBasicAuth(target.user, target.password)
+	}
+	return req, nil
+}
+
+// _processRequestOptions reads the request settings of a target, like:
+//   monitor.target1.method = POST
+//   monitor.target1.header.Accept = application/json
+//   monitor.target1.body = {"ping": true}
+// Invalid settings are skipped and added to the problems.
+func _processRequestOptions(props map[string]string, prefix string, target *Target, problems *[]error) {
+	if strVal, ok := props[prefix+".method"]; ok {
+		method := strings.ToUpper(strVal)
+		if containsString(httpMethods, method) {
+			target.method = method
+		} else {
+			*problems = append(*problems, fmt.Errorf("invalid %s.method value: %s (must be one of %s)", prefix, strVal, strings.Join(httpMethods, ", ")))
+		}
+	}
+
+	headers := prefixedValues(props, prefix+".header.")
+	for name, value := range headers {
+		if !validHeaderName(name) {
+			*problems = append(*problems, fmt.Errorf("invalid header name %s.header.%s", prefix, name))
+			delete(headers, name)
+		} else if strings.ContainsAny(value, "\r\n") {
+			*problems = append(*problems, fmt.Errorf("invalid %s.header.%s value: it can't have a line break", prefix, name))
+			delete(headers, name)
+		}
+	}
+	if len(headers) > 0 {
+		target.headers = headers
+	}
+
+	body, hasBody := props[prefix+".body"]
+	if fileName, ok := props[prefix+".bodyFile"]; ok {
+		if hasBody {
+			*problems = append(*problems, fmt.Errorf("%s.body and %s.bodyFile can't both be set", prefix, prefix))
+		} else if contents, err := ioutil.ReadFile(fileName); err != nil {
+			*problems = append(*problems, fmt.Errorf("invalid %s.bodyFile value: %s", prefix, err))
+		} else {
+			target.body = contents
+		}
+	} else if hasBody {
+		target.body = []byte(body)
+	}
+
+	if strVal, ok := props[prefix+".contentType"]; ok {
+		if _, _, err := mime.ParseMediaType(strVal); err != nil {
+			*problems = append(*problems, fmt.Errorf("invalid %s.contentType value: %s: %s", prefix, strVal, err))
+		} else if hasHeader(headers, "Content-Type") {
+			*problems = append(*problems, fmt.Errorf("%s.contentType and %s.header.Content-Type can't both be set", prefix, prefix))
+		} else {
+			target.contentType = strVal
+		}
+	}
+}
+
+// validHeaderName returns true when the name only has the characters allowed in an HTTP header name
+func validHeaderName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c > '~' || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasHeader returns true when the headers have the name, in any case
+func hasHeader(headers map[string]string, name string) bool {
+	for header := range headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
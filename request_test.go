@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_targetRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || string(body) != `{"ping": true}` || r.Host != "internal.example.com" ||
+			r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Api-Key") != "abc" {
+			t.Errorf("unexpected request: %s %s %s %v", r.Method, r.Host, body, r.Header)
+		}
+	}))
+	defer server.Close()
+
+	props := map[string]string{
+		"monitor.target1.method":           "post",
+		"monitor.target1.header.X-Api-Key": "abc",
+		"monitor.target1.header.Host":      "internal.example.com",
+		"monitor.target1.body":             `{"ping": true}`,
+		"monitor.target1.contentType":      "application/json",
+	}
+	target := Target{host: "internal", url: server.URL}
+	if problems := _processTargetOptions(props, "monitor.target1", &target); len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	req, err := newTargetRequest(&target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	invalid := map[string]string{
+		"monitor.target1.method":          "FETCH",
+		"monitor.target1.header.Bad Name": "x",
+		"monitor.target1.body":            "x",
+		"monitor.target1.bodyFile":        "/no/such/file",
+		"monitor.target1.contentType":     "application/",
+	}
+	problems := _processTargetOptions(invalid, "monitor.target1", &Target{})
+	if len(problems) != 4 {
+		t.Errorf("expected 4 problems, got %d: %v", len(problems), problems)
+	}
+	for _, problem := range problems {
+		if !strings.Contains(problem.Error(), "monitor.target1.") {
+			t.Errorf("expected the problem to name the setting: %s", problem)
+		}
+	}
+}
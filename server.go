@@ -0,0 +1,29 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// serveHTTP runs the optional embedded HTTP server.  It serves:
+//   /         a dashboard of the status of every target
+//   /metrics  per-target metrics in the Prometheus text format
+//   /api/     the REST API for managing targets, when an apiToken is configured
+func serveHTTP(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/", dashboard)
+	mux.Handle("/metrics", metrics)
+	if len(apiToken) > 0 {
+		mux.Handle("/api/", &apiHandler{token: apiToken})
+	}
+
+	log.Printf("Serving HTTP on %s\n", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Printf("Error serving HTTP on %s: %s\n", address, err)
+	}
+}
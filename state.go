@@ -0,0 +1,94 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"time"
+)
+
+// targetState is the health of a target as seen by its monitor
+type targetState int
+
+const (
+	stateUnknown targetState = iota // not checked yet
+	stateUp
+	stateDown
+)
+
+func (s targetState) String() string {
+	switch s {
+	case stateUp:
+		return "UP"
+	case stateDown:
+		return "DOWN"
+	}
+	return "UNKNOWN"
+}
+
+// checkResult is the outcome of one check, kept for the failure thresholds
+type checkResult struct {
+	time time.Time
+	err  error
+}
+
+// recordResult adds a check result to the window of recent results
+func (t *Target) recordResult(when time.Time, err error) {
+	size := t.failureWindowSize()
+	// A new slice, see Target
+	recent := append([]checkResult{}, t.recent...)
+	recent = append(recent, checkResult{time: when, err: err})
+	if len(recent) > size {
+		recent = recent[len(recent)-size:]
+	}
+	t.recent = recent
+}
+
+// removedCopy returns the copy of a down target that is sent to the alerts channel
+// when it is no longer monitored
+func (t *Target) removedCopy() *Target {
+	removed := *t
+	removed.err = nil
+	removed.removed = true
+	removed.lastOutage = time.Now().Sub(t.downSince)
+	return &removed
+}
+
+// failedAttempts returns the failed checks in the window of recent results
+func (t *Target) failedAttempts() []checkResult {
+	failed := []checkResult{}
+	for _, result := range t.recent {
+		if result.err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// failuresNeeded returns how many failures in the window cause an alert
+func (t *Target) failuresNeeded() int {
+	if t.failuresBeforeAlert > 0 {
+		return t.failuresBeforeAlert
+	}
+	return live().failuresBeforeAlert
+}
+
+// failureWindowSize returns the number of recent checks the failures are counted in.
+// It is never smaller than the failures needed, which means consecutive failures by default.
+func (t *Target) failureWindowSize() int {
+	size := live().failureWindow
+	if t.failureWindow > 0 {
+		size = t.failureWindow
+	}
+	if needed := t.failuresNeeded(); size < needed {
+		return needed
+	}
+	return size
+}
+
+// suspectedDown returns true when there are recent failures, but not enough to alert yet
+func (t *Target) suspectedDown() bool {
+	return t.state != stateDown && len(t.failedAttempts()) > 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_checkTarget(t *testing.T) {
+	savedGet := doGet
+	defer func() { doGet = savedGet }()
+	var nextErr error
+	doGet = func(target *Target) error {
+		return nextErr
+	}
+
+	target := &Target{host: "shop", url: "https://shop.example.com", failuresBeforeAlert: 2, failureWindow: 3,
+		retryInterval: 5 * time.Second, monitorInterval: time.Minute, disableInterval: time.Hour}
+	defer dashboard.forget(target)
+	defer metrics.forget(target)
+	alerts := make(chan *Target, 10)
+	control := newMonitorControl()
+	check := func(err error) *Target {
+		nextErr = err
+		checkTarget(target, alerts, control)
+		select {
+		case alert := <-alerts:
+			return alert
+		default:
+			return nil
+		}
+	}
+	failure := errors.New("HTTP Error code: 503")
+
+	// A failure alone doesn't alert, but the target is checked again sooner
+	beforeFirst := time.Now()
+	if alert := check(failure); alert != nil || target.state == stateDown {
+		t.Fatal("expected no alert after one failure")
+	}
+	afterFirst := time.Now()
+	if target.nextInterval() != 5*time.Second {
+		t.Errorf("expected the retry interval, got %s", target.nextInterval())
+	}
+	if alert := check(nil); alert != nil {
+		t.Errorf("expected no alert after a success, got %v", alert)
+	}
+
+	// Two failures in a window of three is an outage, which started with the first failure
+	alert := check(failure)
+	if alert == nil || alert.err != failure || target.state != stateDown {
+		t.Fatalf("expected an alert after 2 of 3 checks failed, got %v", alert)
+	}
+	if target.downSince.Before(beforeFirst) || target.downSince.After(afterFirst) {
+		t.Errorf("expected the outage to start at the first failure, got %s", target.downSince)
+	}
+	if target.nextInterval() != time.Minute {
+		t.Errorf("expected the monitor interval while down, got %s", target.nextInterval())
+	}
+
+	// The alert is repeated every disableInterval while the outage lasts
+	if alert := check(failure); alert != nil {
+		t.Error("expected no alert within the disable interval")
+	}
+	target.lastAlert = time.Now().Add(-time.Hour)
+	if alert := check(failure); alert == nil {
+		t.Error("expected the alert to be repeated after the disable interval")
+	}
+
+	// The recovery reports how long the target was down
+	target.downSince = time.Now().Add(-10 * time.Minute)
+	recovered := check(nil)
+	if recovered == nil || recovered.err != nil || target.state != stateUp {
+		t.Fatalf("expected a recovery, got %v", recovered)
+	}
+	if recovered.lastOutage < 10*time.Minute || recovered.lastOutage > 11*time.Minute {
+		t.Errorf("expected an outage of 10 minutes, got %s", recovered.lastOutage)
+	}
+	if alert := check(failure); alert != nil || target.state == stateDown {
+		t.Error("expected the failures to be counted again from the recovery")
+	}
+}
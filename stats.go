@@ -7,9 +7,20 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"time"
 )
 
+const (
+	// histogramGrowth is the ratio between the bounds of neighboring histogram buckets,
+	// so a percentile is reported within 5% of its real value
+	histogramGrowth = 1.05
+
+	// histogramSize covers response times from 1ms to over 10 minutes
+	histogramSize = 280
+)
+
 // Stats instances hold a few statistics about HTTP requests
 type Stats struct {
 	StartTime         time.Time
@@ -17,6 +28,12 @@ type Stats struct {
 	TotalResponseTime time.Duration
 	MaxResponseTime   time.Duration
 	MinResponseTime   time.Duration
+	TotalPhases       PhaseTimings
+	MaxPhases         PhaseTimings
+
+	// Histogram counts the samples by response time, see histogramBucket.
+	// It is an array (not a map) so copies of the stats are independent.
+	Histogram [histogramSize]int
 }
 
 // Add an HTTP timing to the stats
@@ -29,10 +46,79 @@ func (s *Stats) Add(d time.Duration) {
 	if s.MinResponseTime == 0 || d < s.MinResponseTime {
 		s.MinResponseTime = d
 	}
+	s.Histogram[histogramBucket(d)]++
+}
+
+// histogramBucket returns the index of the histogram bucket for a response time
+func histogramBucket(d time.Duration) int {
+	if d <= time.Millisecond {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(time.Millisecond)) / math.Log(histogramGrowth)))
+	if i >= histogramSize {
+		return histogramSize - 1
+	}
+	return i
+}
+
+// histogramBound returns the upper bound of a histogram bucket
+func histogramBound(i int) time.Duration {
+	return time.Duration(float64(time.Millisecond) * math.Pow(histogramGrowth, float64(i)))
+}
+
+// Percentile returns the response time that p percent of the samples are at or below,
+// estimated from the histogram
+func (s *Stats) Percentile(p float64) time.Duration {
+	if s.SampleCount == 0 {
+		return time.Duration(0)
+	}
+	rank := int(math.Ceil(p / 100 * float64(s.SampleCount)))
+	count := 0
+	for i, n := range s.Histogram {
+		count += n
+		if count >= rank {
+			// the bucket bound can overshoot the largest sample
+			if bound := histogramBound(i); bound < s.MaxResponseTime {
+				return bound
+			}
+			return s.MaxResponseTime
+		}
+	}
+	return s.MaxResponseTime
+}
+
+// AddPhases adds the phase timings of an HTTP request to the stats.
+// Call it once per sample, along with Add.
+func (s *Stats) AddPhases(p PhaseTimings) {
+	s.TotalPhases.DNS += p.DNS
+	s.TotalPhases.Connect += p.Connect
+	s.TotalPhases.TLS += p.TLS
+	s.TotalPhases.TTFB += p.TTFB
+	s.MaxPhases.DNS = maxDuration(s.MaxPhases.DNS, p.DNS)
+	s.MaxPhases.Connect = maxDuration(s.MaxPhases.Connect, p.Connect)
+	s.MaxPhases.TLS = maxDuration(s.MaxPhases.TLS, p.TLS)
+	s.MaxPhases.TTFB = maxDuration(s.MaxPhases.TTFB, p.TTFB)
+}
+
+// AvgPhases returns the average phase timings since the last call to Clear()
+func (s *Stats) AvgPhases() PhaseTimings {
+	if s.SampleCount == 0 {
+		return PhaseTimings{}
+	}
+	n := time.Duration(s.SampleCount)
+	return PhaseTimings{
+		DNS:     s.TotalPhases.DNS / n,
+		Connect: s.TotalPhases.Connect / n,
+		TLS:     s.TotalPhases.TLS / n,
+		TTFB:    s.TotalPhases.TTFB / n,
+	}
 }
 
 // AvgResponseTime returns the average response time since the last call to Clear()
 func (s *Stats) AvgResponseTime() time.Duration {
+	if s.SampleCount == 0 {
+		return time.Duration(0)
+	}
 	return time.Duration(int64(s.TotalResponseTime) / int64(s.SampleCount))
 }
 
@@ -43,9 +129,83 @@ func (s *Stats) Clear() {
 	s.TotalResponseTime = time.Duration(0)
 	s.MaxResponseTime = time.Duration(0)
 	s.MinResponseTime = time.Duration(0)
+	s.TotalPhases = PhaseTimings{}
+	s.MaxPhases = PhaseTimings{}
+	s.Histogram = [histogramSize]int{}
 }
 
 // String returns a string representation of the stats
 func (s *Stats) String() string {
-	return fmt.Sprintf("Stats: count:%d, avgResponse:%v, maxResponse:%v, minResponse:%v", s.SampleCount, s.AvgResponseTime(), s.MaxResponseTime, s.MinResponseTime)
+	return fmt.Sprintf("Stats: count:%d, avgResponse:%v, maxResponse:%v, minResponse:%v, p50:%v, p90:%v, p95:%v, p99:%v, avgPhases:[%v], maxPhases:[%v]",
+		s.SampleCount, s.AvgResponseTime(), s.MaxResponseTime, s.MinResponseTime,
+		s.Percentile(50), s.Percentile(90), s.Percentile(95), s.Percentile(99), s.AvgPhases(), s.MaxPhases)
+}
+
+// timedSample is a response time and when it was recorded
+type timedSample struct {
+	time     time.Time
+	duration time.Duration
+}
+
+// PercentileError is returned when a percentile of the recent response times is over its threshold
+type PercentileError struct {
+	Percentile float64
+	Value      time.Duration
+	Max        time.Duration
+	Window     time.Duration
+	Samples    int
+}
+
+func (e *PercentileError) Error() string {
+	return fmt.Sprintf("p%g response time over the last %v was %v which is over the %v threshold (%d samples)",
+		e.Percentile, e.Window, e.Value, e.Max, e.Samples)
+}
+
+// addToWindow keeps the response time for the percentile alert rule, dropping the ones outside its window
+func (t *Target) addToWindow(when time.Time, d time.Duration) {
+	config := live()
+	if config.alertPercentile == 0 {
+		return
+	}
+	// A new slice, see Target
+	window := []timedSample{}
+	for _, sample := range t.window {
+		if when.Sub(sample.time) < config.alertPercentileWindow {
+			window = append(window, sample)
+		}
+	}
+	t.window = append(window, timedSample{time: when, duration: d})
+}
+
+// checkPercentileRule returns a PercentileError when the configured percentile of the
+// response times in the window is over the threshold
+func checkPercentileRule(target *Target) error {
+	config := live()
+	if config.alertPercentile == 0 || len(target.window) == 0 {
+		return nil
+	}
+	durations := []time.Duration{}
+	for _, sample := range target.window {
+		durations = append(durations, sample.duration)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	// nearest rank
+	rank := int(math.Ceil(config.alertPercentile / 100 * float64(len(durations))))
+	if rank < 1 {
+		rank = 1
+	}
+	value := durations[rank-1]
+	if value > config.alertPercentileThreshold {
+		return &PercentileError{Percentile: config.alertPercentile, Value: value, Max: config.alertPercentileThreshold,
+			Window: config.alertPercentileWindow, Samples: len(durations)}
+	}
+	return nil
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_statsPercentiles(t *testing.T) {
+	stats := Stats{}
+	stats.Clear()
+	for i := 1; i <= 100; i++ {
+		stats.Add(time.Duration(i) * 10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		percentile float64
+		expected   time.Duration
+	}{
+		{50, 500 * time.Millisecond},
+		{90, 900 * time.Millisecond},
+		{99, 990 * time.Millisecond},
+		{100, time.Second},
+	}
+	for _, test := range tests {
+		actual := stats.Percentile(test.percentile)
+		// histogram buckets are within 5% of the real value
+		if actual < test.expected || float64(actual) > float64(test.expected)*histogramGrowth {
+			t.Errorf("p%g should be about %v, but was %v", test.percentile, test.expected, actual)
+		}
+	}
+
+	stats.Clear()
+	if stats.Percentile(95) != 0 {
+		t.Errorf("p95 of cleared stats should be zero")
+	}
+}
+
+func Test_percentileRule(t *testing.T) {
+	alertPercentile = 95
+	alertPercentileThreshold = 2 * time.Second
+	alertPercentileWindow = 15 * time.Minute
+	publishSettings()
+	defer func() {
+		alertPercentile = 0
+		publishSettings()
+	}()
+
+	target := &Target{host: "tst-123", url: "https://tst-123/api/Ping"}
+	start := time.Now().Add(-time.Hour)
+
+	// An old slow response falls out of the window
+	target.addToWindow(start, 10*time.Second)
+	for i := 1; i <= 19; i++ {
+		target.addToWindow(start.Add(30*time.Minute+time.Duration(i)*time.Minute), time.Second)
+	}
+	if err := checkPercentileRule(target); err != nil {
+		t.Errorf("expected no error, got: %s", err)
+	}
+
+	// Two slow responses out of the 15 in the window put the p95 over the threshold
+	target.addToWindow(start.Add(50*time.Minute), 3*time.Second)
+	target.addToWindow(start.Add(51*time.Minute), 3*time.Second)
+	if err := checkPercentileRule(target); err == nil {
+		t.Errorf("expected a percentile error, window has %d samples", len(target.window))
+	}
+}
@@ -0,0 +1,71 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxRedirects matches the limit used by the default http.Client
+const defaultMaxRedirects = 10
+
+// statusRange is an inclusive range of accepted HTTP status codes
+type statusRange struct {
+	low  int
+	high int
+}
+
+// parseStatusRanges converts a value like "200-299, 304" into status ranges
+func parseStatusRanges(value string) ([]statusRange, error) {
+	ranges := []statusRange{}
+	for _, part := range commaSplittingRegex.Split(strings.TrimSpace(value), -1) {
+		bounds := strings.SplitN(part, "-", 2)
+		low, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q", part)
+		}
+		high := low
+		if len(bounds) > 1 {
+			if high, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+				return nil, fmt.Errorf("invalid status code range %q", part)
+			}
+		}
+		if low < 100 || high > 599 || low > high {
+			return nil, fmt.Errorf("status code range out of bounds %q", part)
+		}
+		ranges = append(ranges, statusRange{low: low, high: high})
+	}
+	return ranges, nil
+}
+
+// statusAccepted returns true when the code is in one of the ranges.  With no ranges configured,
+// anything below 400 is accepted, or only 2xx when redirects are not followed, since a redirect
+// (to a login page, say) is then the response that is checked.
+func statusAccepted(ranges []statusRange, followRedirects bool, code int) bool {
+	if len(ranges) == 0 && !followRedirects {
+		return code >= 200 && code < 300
+	} else if len(ranges) == 0 {
+		return code < 400
+	}
+	for _, r := range ranges {
+		if code >= r.low && code <= r.high {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusError is returned by doGet when the response status (or the URL it ended on) is not accepted
+type StatusError struct {
+	Reason string
+	Chain  []string // each status and URL observed, including redirects
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s (status chain: %s)", e.Reason, strings.Join(e.Chain, " -> "))
+}
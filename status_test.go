@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_parseStatusRanges(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []statusRange // nil when the value is invalid
+	}{
+		{"200", []statusRange{{200, 200}}},
+		{"200-299, 304", []statusRange{{200, 299}, {304, 304}}},
+		{" 200 - 204 ,301", []statusRange{{200, 204}, {301, 301}}},
+		{"", nil},
+		{"ok", nil},
+		{"200-ok", nil},
+		{"99", nil},
+		{"500-600", nil},
+		{"299-200", nil},
+	}
+	for _, test := range tests {
+		ranges, err := parseStatusRanges(test.value)
+		if test.expected == nil && err == nil {
+			t.Errorf("expected %q to be invalid, got %v", test.value, ranges)
+		} else if test.expected != nil && !reflect.DeepEqual(ranges, test.expected) {
+			t.Errorf("expected %q to be %v, got %v %v", test.value, test.expected, ranges, err)
+		}
+	}
+}
+
+func Test_statusAccepted(t *testing.T) {
+	configured := []statusRange{{200, 299}, {304, 304}}
+	tests := []struct {
+		ranges          []statusRange
+		followRedirects bool
+		code            int
+		expected        bool
+	}{
+		{nil, true, 200, true},
+		{nil, true, 302, true},
+		{nil, true, 404, false},
+		{nil, false, 204, true},
+		{nil, false, 302, false}, // the redirect to a login page is not healthy
+		{nil, false, 503, false},
+		{configured, true, 304, true},
+		{configured, false, 304, true},
+		{configured, true, 301, false},
+		{configured, true, 500, false},
+	}
+	for _, test := range tests {
+		if accepted := statusAccepted(test.ranges, test.followRedirects, test.code); accepted != test.expected {
+			t.Errorf("expected %d to be accepted=%t by %v (followRedirects=%t)", test.code, test.expected, test.ranges, test.followRedirects)
+		}
+	}
+}
+
+func Test_redirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusFound)
+		case "/account":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		target   Target
+		expected string // the error, empty when the check succeeds
+	}{
+		{Target{url: server.URL + "/a"}, ""},
+		{Target{url: server.URL + "/a", maxRedirects: 2, finalURL: server.URL + "/c"}, ""},
+		{Target{url: server.URL + "/a", maxRedirects: 1},
+			"stopped after 1 redirects (status chain: 302 " + server.URL + "/a -> 302 " + server.URL + "/b)"},
+		{Target{url: server.URL + "/a", finalURL: server.URL + "/home"},
+			"unexpected final URL: " + server.URL + "/c (status chain: 302 " + server.URL + "/a -> 302 " + server.URL + "/b -> 200 " + server.URL + "/c)"},
+		{Target{url: server.URL + "/account", noFollowRedirects: true},
+			"HTTP Error code: 302 Found (status chain: 302 " + server.URL + "/account)"},
+		{Target{url: server.URL + "/account", noFollowRedirects: true, expectedStatus: []statusRange{{302, 302}}}, ""},
+	}
+	for i, test := range tests {
+		target := test.target
+		target.host = "shop"
+		_, _, err := doRequest(&target)
+		if len(test.expected) == 0 && err != nil {
+			t.Errorf("check %d: expected no error, got %s", i+1, err)
+		} else if len(test.expected) > 0 {
+			if _, ok := err.(*StatusError); !ok || err.Error() != test.expected {
+				t.Errorf("check %d: expected the error %q, got %v", i+1, test.expected, err)
+			}
+		}
+	}
+}
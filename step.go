@@ -0,0 +1,284 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A target with steps is a transaction, like logging in and then opening a page.
+// The steps have the same settings as a target, and can pull values out of their
+// responses into variables that the later steps use as ${name}:
+//   monitor.target1 = shop, https://shop.example.com/account
+//   monitor.target1.step1.url = https://shop.example.com/login
+//   monitor.target1.step1.extract.csrf = regex name="csrf" value="([^"]+)"
+//   monitor.target1.step2.url = https://shop.example.com/login
+//   monitor.target1.step2.method = POST
+//   monitor.target1.step2.body = user=joe&password=secret&csrf=${csrf}
+//   monitor.target1.step2.contentType = application/x-www-form-urlencoded
+//   monitor.target1.step2.maxResponseTime = 2s
+//   monitor.target1.step3.url = https://shop.example.com/account
+//   monitor.target1.step3.assert1 = contains My account
+// The steps use the user, password, headers, timeouts, proxy, and TLS settings of the target unless
+// they set their own, and share its cookies and connections.
+
+var stepPrefixRegex = regexp.MustCompile(`\.step\d+$`)
+var variableRegex = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// excerptLength is how much of a failed step's response is shown in the alert
+const excerptLength = 300
+
+// extraction pulls a value out of a step's response into a variable, using one of:
+//   regex <pattern>  the first group of the pattern (or the whole match when it has no group)
+//   json <path>      the value at a path like auth.token
+//   header <name>    the value of a response header
+type extraction struct {
+	variable string
+	kind     string
+	text     string
+	pattern  *regexp.Regexp
+	elements []interface{}
+}
+
+// stepTiming is how long a step of a transaction took
+type stepTiming struct {
+	Step string
+	Took time.Duration
+}
+
+func (s stepTiming) String() string {
+	return fmt.Sprintf("%s:%v", s.Step, s.Took.Truncate(time.Millisecond))
+}
+
+// StepError is returned by doGet when a step of a transaction fails
+type StepError struct {
+	Step    string // e.g. step2
+	URL     string
+	Err     error
+	Excerpt string // the start of the step's response body, if there was one
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s (%s) failed: %s", e.Step, e.URL, e.Err)
+}
+
+// doSteps makes the requests of a transaction in order, and stops at the first one that fails
+func doSteps(target *Target) error {
+	variables := map[string]string{}
+	target.stepTimings = []stepTiming{}
+	target.phases = PhaseTimings{}
+	var contents []byte
+	for i := range target.steps {
+		step := target.stepRequest(i, variables)
+		t := time.Now()
+		body, header, err := doRequest(&step)
+		target.stepTimings = append(target.stepTimings, stepTiming{Step: step.host, Took: time.Now().Sub(t)})
+		target.phases = target.phases.add(step.phases)
+		target.lastStatus = step.lastStatus
+		if step.peerCert != nil {
+			target.peerCert = step.peerCert
+		}
+		if err == nil {
+			err = extractVariables(step.extractions, body, header, variables)
+		}
+		if err != nil {
+			return &StepError{Step: step.host, URL: step.url, Err: err, Excerpt: excerpt(body)}
+		}
+		contents = body
+	}
+
+	// The target's own assertions are checked against the response of the last step
+	for _, assertion := range target.assertions {
+		if err := assertion.Check(contents); err != nil {
+			last := target.steps[len(target.steps)-1]
+			return &StepError{Step: last.host, URL: last.url, Err: err, Excerpt: excerpt(contents)}
+		}
+	}
+	return nil
+}
+
+// stepRequest returns a copy of a step with the variables filled in (escaped in the URL and body), and the
+// target's settings for the ones the step does not set.  The host of the copy is the name of the step, e.g. step1.
+func (t *Target) stepRequest(i int, variables map[string]string) Target {
+	step := t.steps[i]
+	fill := func(text string, escape func(string) string) string {
+		return variableRegex.ReplaceAllStringFunc(text, func(name string) string {
+			if value, ok := variables[name[2:len(name)-1]]; ok {
+				return escape(value)
+			}
+			return name
+		})
+	}
+	raw := func(value string) string { return value }
+	step.url = fill(step.url, url.QueryEscape)
+	headers := map[string]string{}
+	for name, value := range t.headers {
+		headers[name] = value
+	}
+	for name, value := range step.headers {
+		headers[name] = fill(value, raw)
+	}
+	step.headers = headers
+	contentType := step.contentType
+	for name, value := range headers {
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+		}
+	}
+	step.body = []byte(fill(string(step.body), bodyEscape(contentType)))
+	if len(step.user) == 0 {
+		step.user, step.password = t.user, t.password
+	}
+	step.maxResponseTime = durationOrDefault(step.maxResponseTime, t.maxResponse())
+	step.connectTimeout = durationOrDefault(step.connectTimeout, t.connectTimeout)
+	if step.connection == (connectionOptions{}) {
+		step.connection = t.connection
+	}
+	step.jar = t.cookies()
+	step.transports = t.transportPool()
+	return step
+}
+
+// bodyEscape returns how the variables are escaped in a step's body of the content type:
+// URL-encoded in a form, and as the inside of a string in JSON.  Other bodies get them as they are.
+func bodyEscape(contentType string) func(string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return url.QueryEscape
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return func(value string) string {
+			bytes, _ := json.Marshal(value)
+			return string(bytes[1 : len(bytes)-1])
+		}
+	}
+	return func(value string) string { return value }
+}
+
+// extractVariables sets the variables from the response of a step
+func extractVariables(extractions []extraction, body []byte, header http.Header, variables map[string]string) error {
+	for _, e := range extractions {
+		value, found := "", false
+		switch e.kind {
+		case "regex":
+			if match := e.pattern.FindSubmatch(body); match != nil {
+				value, found = string(match[len(match)-1]), true
+			}
+		case "json":
+			var doc interface{}
+			if err := json.Unmarshal(body, &doc); err != nil {
+				return fmt.Errorf("can't extract %s, the response body is not valid JSON: %s", e.variable, err)
+			}
+			var v interface{}
+			if v, found = jsonLookup(doc, e.elements); found {
+				value = jsonString(v)
+			}
+		case "header":
+			value = header.Get(e.text)
+			found = len(value) > 0
+		}
+		if !found {
+			return fmt.Errorf("can't extract %s, %s %s was not found in the response", e.variable, e.kind, e.text)
+		}
+		variables[e.variable] = value
+	}
+	return nil
+}
+
+// excerpt returns the start of a response body on one line
+func excerpt(body []byte) string {
+	text := strings.Join(strings.Fields(string(body)), " ")
+	if len(text) > excerptLength {
+		return truncate(text, excerptLength) + "..."
+	}
+	return text
+}
+
+// _processSteps reads the steps of a transaction, which must be sequential: step1, step2, ...
+// Invalid steps are skipped and returned as problems.
+func _processSteps(props map[string]string, prefix string, target *Target) []error {
+	problems := []error{}
+	extracted := map[string]bool{}
+	for i := 1; ; i++ {
+		name := "step" + strconv.Itoa(i)
+		stepPrefix := prefix + "." + name
+		stepURL, ok := props[stepPrefix+".url"]
+		if !ok {
+			break // Assume there are no more steps for this target
+		}
+		if err := validateTargetURL(variableRegex.ReplaceAllString(stepURL, "x")); err != nil {
+			problems = append(problems, fmt.Errorf("invalid %s.url value: %s", stepPrefix, err))
+			continue
+		}
+
+		step := Target{host: name, url: stepURL}
+		if user, ok := props[stepPrefix+".user"]; ok {
+			step.user, step.password = user, props[stepPrefix+".password"]
+		}
+		problems = append(problems, _processTargetOptions(props, stepPrefix, &step)...)
+
+		// The variables a step uses must be extracted by an earlier step
+		used := stepURL + string(step.body)
+		for _, value := range step.headers {
+			used += value
+		}
+		for _, match := range variableRegex.FindAllStringSubmatch(used, -1) {
+			if !extracted[match[1]] {
+				problems = append(problems, fmt.Errorf("%s uses ${%s}, which no earlier step extracts", stepPrefix, match[1]))
+			}
+		}
+
+		values := prefixedValues(props, stepPrefix+".extract.")
+		variables := []string{}
+		for variable := range values {
+			variables = append(variables, variable)
+		}
+		sort.Strings(variables)
+		for _, variable := range variables {
+			e, err := parseExtraction(variable, values[variable])
+			if err != nil {
+				problems = append(problems, fmt.Errorf("invalid %s.extract.%s value: %s", stepPrefix, variable, err))
+				continue
+			}
+			step.extractions = append(step.extractions, e)
+			extracted[variable] = true
+		}
+		target.steps = append(target.steps, step)
+	}
+	return problems
+}
+
+// parseExtraction converts a config value like "json auth.token" into an extraction
+func parseExtraction(variable, text string) (extraction, error) {
+	parts := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	if len(parts) < 2 || len(strings.TrimSpace(parts[1])) == 0 {
+		return extraction{}, fmt.Errorf("extraction must have a type and a value: %s", text)
+	}
+	e := extraction{variable: variable, kind: parts[0], text: strings.TrimSpace(parts[1])}
+	var err error
+	switch e.kind {
+	case "regex":
+		e.pattern, err = regexp.Compile(e.text)
+	case "json":
+		e.elements, err = parseJSONPath(e.text)
+	case "header":
+		if !validHeaderName(e.text) {
+			err = fmt.Errorf("invalid header name %q", e.text)
+		}
+	default:
+		err = fmt.Errorf("unknown extraction type %q (expected regex, json, or header)", e.kind)
+	}
+	return e, err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_doSteps(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			if r.Method == "GET" {
+				w.Write([]byte(`<input name="csrf" value="c5rf">`))
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != "user=joe&csrf=c5rf" {
+				http.Error(w, "bad login", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"auth": {"token": "t0ken"}}`))
+		case "/account":
+			if r.Header.Get("X-Token") != "t0ken" {
+				http.Error(w, "not logged in", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("Welcome to your account"))
+		}
+	}))
+	defer server.Close()
+
+	props := map[string]string{
+		"monitor.target1.assert1":              "contains Welcome",
+		"monitor.target1.step1.url":            server.URL + "/login",
+		"monitor.target1.step1.extract.csrf":   `regex name="csrf" value="([^"]+)"`,
+		"monitor.target1.step2.url":            server.URL + "/login",
+		"monitor.target1.step2.method":         "POST",
+		"monitor.target1.step2.body":           "user=joe&csrf=${csrf}",
+		"monitor.target1.step2.extract.token":  "json auth.token",
+		"monitor.target1.step3.url":            server.URL + "/account",
+		"monitor.target1.step3.header.X-Token": "${token}",
+	}
+	target := Target{host: "shop", url: server.URL + "/account"}
+	if problems := _processTargetOptions(props, "monitor.target1", &target); len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	if len(target.steps) != 3 {
+		t.Fatalf("expected 3 steps, got %d", len(target.steps))
+	}
+	if err := doSteps(&target); err != nil {
+		t.Fatal(err)
+	}
+	if len(target.stepTimings) != 3 || target.lastStatus != 200 {
+		t.Errorf("expected the timings of 3 steps and the last status, got %v %d", target.stepTimings, target.lastStatus)
+	}
+
+	// The failed step is named in the error, with an excerpt of its response
+	target.steps[1].body = []byte("user=joe")
+	err := doSteps(&target)
+	stepErr, ok := err.(*StepError)
+	if !ok || stepErr.Step != "step2" || !strings.Contains(stepErr.Excerpt, "bad login") {
+		t.Errorf("expected step2 to fail with its response, got %v", err)
+	}
+	if len(target.stepTimings) != 2 {
+		t.Errorf("expected the steps to stop at the failed one, got %v", target.stepTimings)
+	}
+
+	invalid := map[string]string{
+		"monitor.target1.step1.url":          server.URL + "/login",
+		"monitor.target1.step1.extract.csrf": "xpath //input",
+		"monitor.target1.step2.url":          server.URL + "/account?token=${token}",
+	}
+	if problems := _processTargetOptions(invalid, "monitor.target1", &Target{}); len(problems) != 2 {
+		t.Errorf("expected 2 problems, got %d: %v", len(problems), problems)
+	}
+}
+
+func Test_stepRequestEscapes(t *testing.T) {
+	variables := map[string]string{"csrf": `a+b&c="d"`}
+	tests := []struct {
+		step     Target
+		expected string
+	}{
+		{Target{body: []byte("csrf=${csrf}"), contentType: "application/x-www-form-urlencoded"}, "csrf=a%2Bb%26c%3D%22d%22"},
+		{Target{body: []byte(`{"csrf": "${csrf}"}`), headers: map[string]string{"content-type": "application/json; charset=utf-8"}}, `{"csrf": "a+b\u0026c=\"d\""}`},
+		{Target{body: []byte("csrf=${csrf}, ${other}")}, `csrf=a+b&c="d", ${other}`},
+	}
+	for _, test := range tests {
+		target := &Target{host: "shop", steps: []Target{test.step}}
+		if step := target.stepRequest(0, variables); string(step.body) != test.expected {
+			t.Errorf("expected the body %s, got %s", test.expected, step.body)
+		}
+	}
+}
+
+func Test_excerpt(t *testing.T) {
+	body := strings.Repeat("é", excerptLength)
+	text := excerpt([]byte(body))
+	if !strings.HasSuffix(text, "...") || len(text) > excerptLength+3 || !utf8.ValidString(text) {
+		t.Errorf("expected a valid excerpt of at most %d bytes, got %q", excerptLength, text)
+	}
+	if text := excerpt([]byte(" a\n b ")); text != "a b" {
+		t.Errorf("expected the excerpt on one line, got %q", text)
+	}
+	if text := truncate("aé", 2); text != "a" {
+		t.Errorf("expected the é not to be split, got %q", text)
+	}
+}
@@ -0,0 +1,441 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The reasons monitorControl.wait returns
+const (
+	waitElapsed = iota
+	waitCheckNow
+	waitStopped
+	waitUpdated
+)
+
+// monitorControl lets the supervisor stop, pause, or poke a running monitor
+type monitorControl struct {
+	mutex    sync.Mutex
+	paused   bool
+	stopped  bool // set by the supervisor when the monitor is stopped
+	stop     chan struct{}
+	done     chan struct{} // closed when the monitor has stopped
+	checkNow chan struct{}
+	updated  chan struct{}
+	update   *Target // the new config of the target, set by reconfigure
+}
+
+func newMonitorControl() *monitorControl {
+	return &monitorControl{stop: make(chan struct{}), done: make(chan struct{}), checkNow: make(chan struct{}, 1), updated: make(chan struct{}, 1)}
+}
+
+func (c *monitorControl) isPaused() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.paused
+}
+
+func (c *monitorControl) setPaused(paused bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.paused = paused
+}
+
+// unlessStopped runs the function, unless the monitor was stopped.  The supervisor can't
+// stop the monitor while it runs, so the results of a check in progress when the monitor
+// is stopped are not recorded after the supervisor forgets the target.
+// It holds the lock, so the function must not wait for anything (like disk I/O).
+func (c *monitorControl) unlessStopped(record func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.stopped {
+		record()
+	}
+}
+
+// sendAlert sends a copy of the target to the alerts channel, unless the monitor is stopped first.
+// A monitor stopped while it waits for the main go-routine to take the alert doesn't send it afterwards.
+func (c *monitorControl) sendAlert(alertsChan chan<- *Target, target *Target) {
+	select {
+	case <-c.stop:
+		return
+	default:
+	}
+	select {
+	case alertsChan <- target:
+	case <-c.stop:
+	}
+}
+
+// requestCheck makes the monitor check its target now, even if it is paused
+func (c *monitorControl) requestCheck() {
+	select {
+	case c.checkNow <- struct{}{}:
+	default: // a check is already requested
+	}
+}
+
+// reconfigure hands a new config of the target to the monitor, which applies it when it next waits
+func (c *monitorControl) reconfigure(target Target) {
+	c.mutex.Lock()
+	c.update = &target
+	c.mutex.Unlock()
+	select {
+	case c.updated <- struct{}{}:
+	default: // the monitor has not picked up the last update yet, it will get this one instead
+	}
+}
+
+// takeUpdate returns the config given to reconfigure (nil if there is none) and clears it
+func (c *monitorControl) takeUpdate() *Target {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	update := c.update
+	c.update = nil
+	return update
+}
+
+// wait sleeps for the duration, unless the monitor is stopped, updated, or a check is requested first
+func (c *monitorControl) wait(d time.Duration) int {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return waitElapsed
+	case <-c.checkNow:
+		return waitCheckNow
+	case <-c.updated:
+		return waitUpdated
+	case <-c.stop:
+		return waitStopped
+	}
+}
+
+// targetSpec describes a target added at runtime.  Options are the per-target
+// settings of the config file, without the monitor.targetN prefix, e.g. "assert1".
+type targetSpec struct {
+	Host     string            `json:"host"`
+	URL      string            `json:"url"`
+	User     string            `json:"user,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Options  map[string]string `json:"options,omitempty"`
+}
+
+// toTarget validates the spec and converts it into a Target
+func (spec targetSpec) toTarget() (Target, error) {
+	if len(spec.Host) == 0 {
+		return Target{}, errors.New("host is required")
+	}
+	if err := validateTargetURL(spec.URL); err != nil {
+		return Target{}, err
+	}
+	for name := range spec.Options {
+		option := name[strings.LastIndex(name, ".")+1:]
+		if containsString(serverFileOptions, option) {
+			return Target{}, fmt.Errorf("%s can't be set through the API, because it reads a file on the server", name)
+		}
+	}
+	target := Target{host: spec.Host, url: spec.URL, user: spec.User, password: spec.Password}
+	props := map[string]string{}
+	for name, value := range spec.Options {
+		props["options."+name] = value
+	}
+	if problems := _processTargetOptions(props, "options", &target); len(problems) > 0 {
+		return Target{}, problems[0]
+	}
+	return target, nil
+}
+
+// runningMonitor is a target being monitored by a go-routine
+type runningMonitor struct {
+	target  Target
+	control *monitorControl
+	spec    *targetSpec // set when the target was added at runtime
+}
+
+// supervisor starts and stops the monitor go-routines, and saves runtime changes to the state file
+type supervisor struct {
+	mutex      sync.Mutex
+	alertsChan chan<- *Target
+	monitors   map[string]*runningMonitor // keyed by target id
+	removed    map[string]bool            // ids of configured targets removed at runtime
+	stateFile  string                     // where runtime changes are saved, not saved when empty
+
+	pausedAtStart map[string]bool // ids of targets that were paused when the state was saved
+}
+
+// This is set in main
+var monitors *supervisor
+
+func newSupervisor(alertsChan chan<- *Target, stateFile string) *supervisor {
+	return &supervisor{
+		alertsChan: alertsChan,
+		monitors:   map[string]*runningMonitor{},
+		removed:    map[string]bool{},
+		stateFile:  stateFile,
+
+		pausedAtStart: map[string]bool{},
+	}
+}
+
+// targetID identifies a target in the API and the state file
+func targetID(target *Target) string {
+	return dedupKey(target.host, target.url)
+}
+
+// start begins monitoring the target, unless it is already monitored
+func (s *supervisor) start(target Target, spec *targetSpec) error {
+	loaded := loadDashboardHistory(&target)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.launch(target, spec, loaded)
+}
+
+// launch is start without the locking, with the history of the target already loaded
+func (s *supervisor) launch(target Target, spec *targetSpec, loaded dashboardHistory) error {
+	id := targetID(&target)
+	if _, ok := s.monitors[id]; ok {
+		return fmt.Errorf("%s: %s is already monitored", target.host, target.url)
+	}
+	m := &runningMonitor{target: target, control: newMonitorControl(), spec: spec}
+	m.control.paused = s.pausedAtStart[id]
+	delete(s.pausedAtStart, id) // a target started again later is not paused
+	s.monitors[id] = m
+	delete(s.removed, id)
+	dashboard.register(&target, loaded)
+	go monitorWithControl(target, s.alertsChan, m.control)
+	return nil
+}
+
+// stop ends the monitoring of a target
+func (s *supervisor) stop(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.monitors[id]
+	if !ok {
+		return fmt.Errorf("no target with id %s", id)
+	}
+	s.halt(id, m)
+	if m.spec == nil {
+		s.removed[id] = true
+	}
+	return nil
+}
+
+// halt is stop without the locking, and without remembering that a configured target was removed
+func (s *supervisor) halt(id string, m *runningMonitor) {
+	m.control.mutex.Lock()
+	m.control.stopped = true
+	m.control.mutex.Unlock()
+	close(m.control.stop)
+	delete(s.monitors, id)
+	dashboard.forget(&m.target)
+	metrics.forget(&m.target)
+}
+
+// reload applies a new list of configured targets.  Targets no longer configured are stopped, new ones
+// are started, and changed ones are updated in place so they keep their stats and alert state.
+// A target whose URL changed is found by its host.  Targets added or removed through the REST API stay that way.
+func (s *supervisor) reload(configured []Target) {
+	// The history of the targets that may be added is read before taking the lock
+	loaded := map[string]dashboardHistory{}
+	for _, target := range configured {
+		if _, err := s.control(targetID(&target)); err != nil {
+			loaded[targetID(&target)] = loadDashboardHistory(&target)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	wanted := map[string]bool{}
+	for _, target := range configured {
+		wanted[targetID(&target)] = true
+	}
+	moved := s.movedTargets(configured, wanted)
+
+	for _, target := range configured {
+		id := targetID(&target)
+		if s.removed[id] {
+			continue
+		}
+		m, ok := s.monitors[id]
+		if old, found := moved[id]; !ok && found {
+			log.Printf("Reload changed the url of %s: %s to %s\n", target.host, old.target.url, target.url)
+			delete(s.monitors, targetID(&old.target))
+			s.monitors[id] = old
+			old.target.applyConfig(&target)
+			old.control.reconfigure(target)
+		} else if !ok {
+			log.Printf("Reload added %s: %s\n", target.host, target.url)
+			s.launch(target, nil, loaded[id])
+		} else if m.spec == nil && !sameConfig(&m.target, &target) {
+			log.Printf("Reload changed %s: %s\n", target.host, target.url)
+			m.target.applyConfig(&target)
+			m.control.reconfigure(target)
+		}
+	}
+	for id, m := range s.monitors {
+		if m.spec == nil && !wanted[id] {
+			log.Printf("Reload removed %s: %s\n", m.target.host, m.target.url)
+			s.halt(id, m)
+		}
+	}
+}
+
+// movedTargets finds the configured targets whose URL changed, and returns their running monitors
+// keyed by the new target id.  A target is only matched when its host is used by one new target,
+// and one running target that is no longer configured.  The caller holds the lock.
+func (s *supervisor) movedTargets(configured []Target, wanted map[string]bool) map[string]*runningMonitor {
+	added := map[string][]string{} // the ids of the new targets, by host
+	for _, target := range configured {
+		id := targetID(&target)
+		if _, ok := s.monitors[id]; !ok && !s.removed[id] {
+			added[target.host] = append(added[target.host], id)
+		}
+	}
+	dropped := map[string][]*runningMonitor{} // the running targets no longer configured, by host
+	for id, m := range s.monitors {
+		if m.spec == nil && !wanted[id] {
+			dropped[m.target.host] = append(dropped[m.target.host], m)
+		}
+	}
+
+	moved := map[string]*runningMonitor{}
+	for host, ids := range added {
+		if len(ids) == 1 && len(dropped[host]) == 1 {
+			moved[ids[0]] = dropped[host][0]
+		}
+	}
+	return moved
+}
+
+// control returns the control of a running monitor
+func (s *supervisor) control(id string) (*monitorControl, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.monitors[id]
+	if !ok {
+		return nil, fmt.Errorf("no target with id %s", id)
+	}
+	return m.control, nil
+}
+
+// list returns the running monitors, sorted by host and url
+func (s *supervisor) list() []*runningMonitor {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := []*runningMonitor{}
+	for _, m := range s.monitors {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].target.host != list[j].target.host {
+			return list[i].target.host < list[j].target.host
+		}
+		return list[i].target.url < list[j].target.url
+	})
+	return list
+}
+
+// runtimeState is what is saved in the state file
+type runtimeState struct {
+	Added   []targetSpec `json:"added"`
+	Removed []string     `json:"removed"`
+	Paused  []string     `json:"paused"`
+}
+
+// saveState writes the runtime changes to the state file (if there is one)
+func (s *supervisor) saveState() error {
+	if len(s.stateFile) == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	state := runtimeState{Added: []targetSpec{}, Removed: []string{}, Paused: []string{}}
+	for id, m := range s.monitors {
+		if m.spec != nil {
+			state.Added = append(state.Added, *m.spec)
+		}
+		if m.control.isPaused() {
+			state.Paused = append(state.Paused, id)
+		}
+	}
+	for id := range s.removed {
+		state.Removed = append(state.Removed, id)
+	}
+	s.mutex.Unlock()
+
+	bytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := s.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, bytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.stateFile)
+}
+
+// loadState starts the targets added at runtime, and returns the configured targets
+// that were not removed at runtime.  Call it before starting the configured targets.
+func (s *supervisor) loadState(configured []Target) ([]Target, error) {
+	if len(s.stateFile) == 0 {
+		return configured, nil
+	}
+	bytes, err := ioutil.ReadFile(s.stateFile)
+	if os.IsNotExist(err) {
+		return configured, nil
+	} else if err != nil {
+		return configured, err
+	}
+	var state runtimeState
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		return configured, fmt.Errorf("reading %s: %s", s.stateFile, err)
+	}
+
+	s.mutex.Lock()
+	for _, id := range state.Removed {
+		s.removed[id] = true
+	}
+	for _, id := range state.Paused {
+		s.pausedAtStart[id] = true
+	}
+	s.mutex.Unlock()
+
+	remaining := []Target{}
+	for _, target := range configured {
+		if !s.removed[targetID(&target)] {
+			remaining = append(remaining, target)
+		}
+	}
+
+	// Start the targets that were added at runtime
+	for i := range state.Added {
+		spec := state.Added[i]
+		target, err := spec.toTarget()
+		if err == nil {
+			err = s.start(target, &spec)
+		}
+		if err != nil {
+			log.Printf("Error restoring %s: %s: %s\n", spec.Host, spec.URL, err)
+		}
+	}
+	return remaining, nil
+}
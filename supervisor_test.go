@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_toTargetFileOptions(t *testing.T) {
+	for _, option := range []string{"bodyFile", "step1.bodyFile", "caFile", "clientCert", "clientKey", "step2.caFile"} {
+		spec := targetSpec{Host: "api", URL: "https://api.example.com", Options: map[string]string{option: "/etc/passwd"}}
+		if _, err := spec.toTarget(); err == nil || !strings.Contains(err.Error(), option) {
+			t.Errorf("expected %s to be rejected, got %v", option, err)
+		}
+	}
+
+	spec := targetSpec{Host: "api", URL: "https://api.example.com", Options: map[string]string{"body": "{}", "step1.url": "https://api.example.com/login"}}
+	if target, err := spec.toTarget(); err != nil || len(target.steps) != 1 {
+		t.Errorf("expected the other options to be accepted, got %v", err)
+	}
+}
+
+func Test_sendAlertAfterStop(t *testing.T) {
+	control := newMonitorControl()
+	alerts := make(chan *Target)
+	sent := make(chan struct{})
+	go func() {
+		control.sendAlert(alerts, &Target{host: "shop"})
+		close(sent)
+	}()
+
+	// Nothing takes the alert, so it is dropped when the monitor is stopped
+	close(control.stop)
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the alert to be dropped when the monitor stopped")
+	}
+	select {
+	case <-alerts:
+		t.Error("expected no alert after the monitor stopped")
+	default:
+	}
+}
+
+func Test_stopDownTarget(t *testing.T) {
+	savedGet, savedRemoved := doGet, handleRemoved
+	defer func() { doGet, handleRemoved = savedGet, savedRemoved }()
+	doGet = func(target *Target) error {
+		return errors.New("HTTP Error code: 503")
+	}
+	alerts := make(chan *Target, 10)
+	monitors = newSupervisor(alerts, "")
+	target := Target{host: "shop", url: "https://shop.example.com", failuresBeforeAlert: 1}
+	monitors.start(target, nil)
+	select {
+	case <-alerts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an alert")
+	}
+
+	// Stopping the down target resolves its incidents
+	m := monitors.list()[0]
+	monitors.stop(targetID(&target))
+	<-m.control.done
+	var removed *Target
+	select {
+	case removed = <-alerts:
+	default:
+		t.Fatal("expected the target to be reported as removed")
+	}
+	var handled *Target
+	handleRemoved = func(target *Target) { handled = target }
+	handleAlert(removed)
+	if handled != removed || removed.err != nil || removed.lastOutage <= 0 {
+		t.Errorf("expected the removal to be handled with the outage, got %+v", removed)
+	}
+}
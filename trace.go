@@ -0,0 +1,129 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// PhaseTimings holds how long each phase of an HTTP request took.
+// When redirects are followed, the phases of each request are added together.
+type PhaseTimings struct {
+	DNS     time.Duration // looking up the host name
+	Connect time.Duration // opening the TCP connection
+	TLS     time.Duration // the TLS handshake
+	TTFB    time.Duration // from writing the request to the first byte of the response
+}
+
+// String returns a string representation of the phase timings
+func (p PhaseTimings) String() string {
+	return fmt.Sprintf("dns:%v, connect:%v, tls:%v, ttfb:%v", p.DNS, p.Connect, p.TLS, p.TTFB)
+}
+
+// add returns the sum of both phase timings, like for the steps of a transaction
+func (p PhaseTimings) add(other PhaseTimings) PhaseTimings {
+	return PhaseTimings{DNS: p.DNS + other.DNS, Connect: p.Connect + other.Connect, TLS: p.TLS + other.TLS, TTFB: p.TTFB + other.TTFB}
+}
+
+// PhaseError is returned by doGet when one phase of the request was slower than its threshold
+type PhaseError struct {
+	Phase string
+	Took  time.Duration
+	Max   time.Duration
+}
+
+func (e *PhaseError) Error() string {
+	return fmt.Sprintf("%s phase took %v which is over the %v threshold", e.Phase, e.Took, e.Max)
+}
+
+// checkPhaseThresholds compares each phase with its configured maximum (zero means no maximum)
+func checkPhaseThresholds(p PhaseTimings) error {
+	config := live()
+	checks := []struct {
+		phase string
+		took  time.Duration
+		max   time.Duration
+	}{
+		{"dns", p.DNS, config.maxDNSTime},
+		{"connect", p.Connect, config.maxConnectTime},
+		{"tls", p.TLS, config.maxTLSTime},
+		{"ttfb", p.TTFB, config.maxTTFB},
+	}
+	for _, c := range checks {
+		if c.max > 0 && c.took > c.max {
+			return &PhaseError{Phase: c.phase, Took: c.took, Max: c.max}
+		}
+	}
+	return nil
+}
+
+// phaseTracer records the phase timings of a request using httptrace hooks.
+// The hooks can be called from other go-routines, hence the lock.
+type phaseTracer struct {
+	mutex        sync.Mutex
+	timings      PhaseTimings
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+}
+
+// Timings returns the phase timings recorded so far
+func (t *phaseTracer) Timings() PhaseTimings {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.timings
+}
+
+// ClientTrace returns the hooks to attach to a request context with httptrace.WithClientTrace
+func (t *phaseTracer) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mutex.Lock()
+			t.dnsStart = time.Now()
+			t.mutex.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mutex.Lock()
+			t.timings.DNS += time.Since(t.dnsStart)
+			t.mutex.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mutex.Lock()
+			t.connectStart = time.Now()
+			t.mutex.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mutex.Lock()
+			t.timings.Connect += time.Since(t.connectStart)
+			t.mutex.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mutex.Lock()
+			t.tlsStart = time.Now()
+			t.mutex.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mutex.Lock()
+			t.timings.TLS += time.Since(t.tlsStart)
+			t.mutex.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mutex.Lock()
+			t.wroteRequest = time.Now()
+			t.mutex.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mutex.Lock()
+			t.timings.TTFB += time.Since(t.wroteRequest)
+			t.mutex.Unlock()
+		},
+	}
+}
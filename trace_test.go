@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_checkPhaseThresholds(t *testing.T) {
+	saved := currentSettings()
+	maxDNSTime, maxConnectTime, maxTLSTime, maxTTFB = 10*time.Millisecond, 20*time.Millisecond, 30*time.Millisecond, 0
+	publishSettings()
+	defer func() {
+		saved.restore()
+		publishSettings()
+	}()
+
+	ms := time.Millisecond
+	tests := []struct {
+		timings PhaseTimings
+		phase   string // the phase over its threshold, empty for none
+	}{
+		{PhaseTimings{}, ""},
+		{PhaseTimings{DNS: 10 * ms, Connect: 20 * ms, TLS: 30 * ms}, ""},
+		{PhaseTimings{DNS: 11 * ms}, "dns"},
+		{PhaseTimings{Connect: 21 * ms}, "connect"},
+		{PhaseTimings{TLS: 31 * ms}, "tls"},
+		{PhaseTimings{TTFB: time.Hour}, ""}, // no maximum
+		{PhaseTimings{DNS: 11 * ms, TLS: 31 * ms}, "dns"},
+	}
+	for _, test := range tests {
+		err := checkPhaseThresholds(test.timings)
+		if len(test.phase) == 0 && err != nil {
+			t.Errorf("expected no error for %s, got %s", test.timings, err)
+		} else if phaseErr, ok := err.(*PhaseError); len(test.phase) > 0 && (!ok || phaseErr.Phase != test.phase) {
+			t.Errorf("expected the %s phase to be over its threshold for %s, got %v", test.phase, test.timings, err)
+		}
+	}
+
+	maxTTFB = 40 * ms
+	publishSettings()
+	if err, ok := checkPhaseThresholds(PhaseTimings{TTFB: 41 * ms}).(*PhaseError); !ok || err.Phase != "ttfb" || err.Max != 40*ms {
+		t.Errorf("expected the ttfb phase to be over its threshold, got %v", err)
+	}
+}
+
+func Test_phaseTracer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// A host name, so it is looked up
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	defer client.CloseIdleConnections()
+
+	tracer := &phaseTracer{}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tracer.ClientTrace()))
+	response, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	timings := tracer.Timings()
+	if timings.DNS <= 0 || timings.Connect <= 0 || timings.TLS <= 0 {
+		t.Errorf("expected the dns, connect, and tls phases to be measured, got %s", timings)
+	}
+	if timings.TTFB < 20*time.Millisecond {
+		t.Errorf("expected the first byte to take at least 20ms, got %s", timings.TTFB)
+	}
+}
@@ -0,0 +1,159 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+const defaultWebhookTemplate = `{"kind": {{json .Kind}}, "subject": {{json .Subject}}, "host": {{json .Host}}, "url": {{json .URL}}, "state": {{json .State}}, "error": {{json .Error}}, "responseTime": {{json .ResponseTime.String}}, "time": {{json .Time}}}`
+
+// webhookFuncs are available in webhook payload templates
+var webhookFuncs = template.FuncMap{
+	// json encodes a value so it can be used safely inside a JSON payload
+	"json": func(v interface{}) (string, error) {
+		bytes, err := json.Marshal(v)
+		return string(bytes), err
+	},
+}
+
+// WebhookNotifier POSTs a templated payload to a URL
+type WebhookNotifier struct {
+	name            string
+	url             string
+	template        *template.Template
+	contentType     string
+	headers         map[string]string
+	timeout         time.Duration
+	retries         int           // extra attempts after the first one fails
+	backoff         time.Duration // wait before the first retry, doubled for each one after that
+	secret          string        // when set, the payload is signed with HMAC-SHA256
+	signatureHeader string
+	events          []string // event kinds to send, all of them when empty
+}
+
+// NewWebhookNotifier creates a webhook notifier with the default settings
+func NewWebhookNotifier(name, url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		name:            name,
+		url:             url,
+		template:        template.Must(template.New(name).Funcs(webhookFuncs).Parse(defaultWebhookTemplate)),
+		contentType:     "application/json",
+		headers:         map[string]string{},
+		timeout:         10 * time.Second,
+		retries:         2,
+		backoff:         time.Second,
+		signatureHeader: "X-Webmon-Signature",
+	}
+}
+
+// SetTemplate parses the payload template
+func (w *WebhookNotifier) SetTemplate(text string) error {
+	t, err := template.New(w.name).Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return err
+	}
+	w.template = t
+	return nil
+}
+
+// Name is part of the Notifier interface
+func (w *WebhookNotifier) Name() string {
+	return w.name
+}
+
+// Notify is part of the Notifier interface
+func (w *WebhookNotifier) Notify(event *Event) error {
+	if !w.wants(event.Kind) {
+		return nil
+	}
+
+	payload := new(bytes.Buffer)
+	if err := w.template.Execute(payload, event); err != nil {
+		return fmt.Errorf("executing payload template: %s", err)
+	}
+	return postWithRetries(w.name, w.timeout, w.retries, w.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", w.url, bytes.NewReader(payload.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", w.contentType)
+		for name, value := range w.headers {
+			req.Header.Set(name, value)
+		}
+		if len(w.secret) > 0 {
+			req.Header.Set(w.signatureHeader, "sha256="+signPayload(w.secret, payload.Bytes()))
+		}
+		return req, nil
+	})
+}
+
+// wants returns true when the notifier is configured to send the kind of event
+func (w *WebhookNotifier) wants(kind string) bool {
+	if len(w.events) == 0 {
+		return true
+	}
+	for _, k := range w.events {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of the payload
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWithRetries sends the request built by newRequest until it succeeds, or the retries run out.
+// Requests are retried after network errors, 429 and 5xx responses, waiting twice as long each time.
+func postWithRetries(name string, timeout time.Duration, retries int, backoff time.Duration, newRequest func() (*http.Request, error)) error {
+	client := &http.Client{Timeout: timeout}
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			if verbose {
+				fmt.Printf("Retrying %s in %v: %s\n", name, backoff, lastErr)
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+
+		if response.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s responded with %s: %s", name, response.Status, bytes.TrimSpace(body))
+		if response.StatusCode != http.StatusTooManyRequests && response.StatusCode < 500 {
+			return lastErr // retrying won't help
+		}
+	}
+	return lastErr
+}
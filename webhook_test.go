@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_signPayload(t *testing.T) {
+	signature := signPayload("key", []byte("The quick brown fox jumps over the lazy dog"))
+	if signature != "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8" {
+		t.Errorf("unexpected signature: %s", signature)
+	}
+}
+
+func Test_webhookNotify(t *testing.T) {
+	standIn := &eventsStandIn{failures: 2, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	webhook := NewWebhookNotifier("webhook1", server.URL)
+	if err := webhook.SetTemplate(`{"text": {{json .Subject}}, "kind": {{json .Kind}}}`); err != nil {
+		t.Fatal(err)
+	}
+	webhook.headers["Authorization"] = "Bearer abc"
+	webhook.secret = "shared-secret"
+	webhook.backoff = 20 * time.Millisecond
+
+	// The failed requests are retried, waiting twice as long each time
+	alert, recovery := testEvents()
+	start := time.Now()
+	if err := webhook.Notify(alert); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Now().Sub(start); elapsed < 60*time.Millisecond {
+		t.Errorf("expected the retries to back off for 60ms, took %s", elapsed)
+	}
+	if len(standIn.requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(standIn.requests))
+	}
+
+	req, body := standIn.requests[2], standIn.bodies[2]
+	if body["text"] != alert.Subject || body["kind"] != eventAlert {
+		t.Errorf("unexpected payload: %v", body)
+	}
+	payload := `{"text": "` + alert.Subject + `", "kind": "alert"}`
+	if req.Header.Get("X-Webmon-Signature") != "sha256="+signPayload("shared-secret", []byte(payload)) {
+		t.Errorf("unexpected signature header: %s", req.Header.Get("X-Webmon-Signature"))
+	}
+	if req.Header.Get("Authorization") != "Bearer abc" || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+
+	// Only the configured kinds of event are sent
+	webhook.events = []string{eventRecovery}
+	if err := webhook.Notify(alert); err != nil || len(standIn.requests) != 3 {
+		t.Errorf("expected the alert not to be sent, got %d requests: %v", len(standIn.requests), err)
+	}
+	if err := webhook.Notify(recovery); err != nil || len(standIn.requests) != 4 || standIn.bodies[3]["kind"] != eventRecovery {
+		t.Errorf("expected the recovery to be sent, got %d requests: %v", len(standIn.requests), err)
+	}
+}
+
+// slowNotifier records the events it is sent, each one after the release channel lets it go
+type slowNotifier struct {
+	release chan bool
+	events  chan *Event
+}
+
+func (n *slowNotifier) Name() string { return "slow" }
+
+func (n *slowNotifier) Notify(event *Event) error {
+	<-n.release
+	n.events <- event
+	return nil
+}
+
+func Test_notifyAll(t *testing.T) {
+	notifier := &slowNotifier{release: make(chan bool), events: make(chan *Event, 2)}
+	savedNotifiers, savedQueue := notifiers, notifications
+	notifiers, notifications = []Notifier{notifier}, make(chan notification, 2)
+	defer func() { notifiers, notifications = savedNotifiers, savedQueue }()
+
+	// Queuing doesn't wait for the notifier, and a full queue drops the event
+	alert, recovery := testEvents()
+	queued := make(chan bool)
+	go func() {
+		notifyAll(alert)
+		notifyAll(recovery)
+		notifyAll(recovery)
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatal("expected notifyAll not to wait for the notifier")
+	}
+
+	// The worker sends the queued events in order
+	queue := notifications
+	go sendNotifications(queue)
+	defer close(queue)
+	for _, expected := range []*Event{alert, recovery} {
+		notifier.release <- true
+		select {
+		case event := <-notifier.events:
+			if event != expected {
+				t.Errorf("expected the %s event, got %s", expected.Kind, event.Kind)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected the %s event to be sent", expected.Kind)
+		}
+	}
+}
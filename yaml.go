@@ -0,0 +1,304 @@
+//
+// Copyright (c) 2015 Jon Carlson.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A YAML config file has the same settings as a properties file, but targets and webhooks are lists:
+//   maxResponseTimeInSeconds: 30
+//   targets:
+//     - host: google
+//       url: https://www.google.com
+//       password: "a password, with a comma"
+//       assertions:
+//         - contains Google
+//       steps:
+//         - url: https://www.google.com/search?q=web-mon
+//   webhooks:
+//     - url: https://chat.example.com/hooks/abc
+//       header:
+//         Authorization: Bearer abc
+// It is read into the same name-value pairs as a properties file, e.g. monitor.target1.assert1
+
+// listItemNames are the names given to the items of the lists in a YAML config file, e.g. the
+// first target is monitor.target1.  The items of other lists are joined with commas, like mailTo.
+var listItemNames = map[string]string{
+	"targets":    "monitor.target",
+	"webhooks":   "webhook",
+	"assertions": "assert",
+	"steps":      "step",
+}
+
+var numberedNameRegex = regexp.MustCompile(`^(monitor\.target|webhook)(\d+)(\.(.+))?$`)
+var assertNameRegex = regexp.MustCompile(`^assert(\d+)$`)
+var stepNameRegex = regexp.MustCompile(`^step(\d+)\.(.+)$`)
+var plainIntegerRegex = regexp.MustCompile(`^(0|-?[1-9][0-9]*)$`)
+
+// isYAMLFile returns true when the file name ends with .yaml or .yml
+func isYAMLFile(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// _readYAMLFile reads a YAML config file into name-value pairs named like the properties
+func _readYAMLFile(fileName string) (map[string]string, error) {
+	bytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(bytes, &doc); err != nil {
+		return nil, err
+	}
+
+	props := map[string]string{}
+	switch doc.(type) {
+	case nil:
+		return props, nil // an empty file
+	case map[interface{}]interface{}, map[string]interface{}:
+		return props, flattenYAML("", doc, props)
+	}
+	return nil, errors.New("a YAML config file must be a map of settings")
+}
+
+// flattenYAML adds the value to the props, with nested maps and lists named like the properties
+func flattenYAML(name string, value interface{}, props map[string]string) error {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			if err := flattenYAML(joinName(name, fmt.Sprint(key)), item, props); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			if err := flattenYAML(joinName(name, key), item, props); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		parent, last := "", name
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			parent, last = name[:i], name[i+1:]
+		}
+		if itemName, ok := listItemNames[last]; ok {
+			for i, item := range v {
+				if err := flattenYAML(joinName(parent, itemName+strconv.Itoa(i+1)), item, props); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		values := []string{}
+		for _, item := range v {
+			switch item.(type) {
+			case map[interface{}]interface{}, map[string]interface{}, []interface{}:
+				return fmt.Errorf("%s must be a list of values", name)
+			}
+			values = append(values, yamlString(item))
+		}
+		props[name] = strings.Join(values, ", ")
+	default:
+		props[name] = yamlString(v)
+	}
+	return nil
+}
+
+func joinName(parent, name string) string {
+	if len(parent) == 0 {
+		return name
+	}
+	return parent + "." + name
+}
+
+// yamlString converts a YAML scalar to the string a properties file would have
+func yamlString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// convertConfigFile prints a properties config file in the YAML format
+func convertConfigFile(fileName string) error {
+	props, err := _readProperties(fileName)
+	if err != nil {
+		return err
+	}
+	fmt.Print(convertConfig(props))
+	return nil
+}
+
+// convertConfig translates properties into a YAML config file.  Targets and webhooks become lists,
+// and so do the numbered assertions and steps of a target.
+func convertConfig(props []property) string {
+	settings := []property{}
+	numbered := map[string]map[int][]property{"monitor.target": {}, "webhook": {}}
+	for _, prop := range props {
+		match := numberedNameRegex.FindStringSubmatch(prop.name)
+		if match == nil {
+			settings = append(settings, prop)
+			continue
+		}
+		n, _ := strconv.Atoi(match[2])
+		if len(match[4]) > 0 {
+			numbered[match[1]][n] = append(numbered[match[1]][n], property{name: match[4], value: prop.value})
+			continue
+		}
+
+		// A target line: host, url, user, password
+		values := commaSplittingRegex.Split(prop.value, 5)
+		line := []property{}
+		for i, name := range []string{"host", "url", "user", "password"} {
+			if i < len(values) && (i < 2 || len(values[i]) > 0) {
+				line = append(line, property{name: name, value: values[i]})
+			}
+		}
+		numbered[match[1]][n] = append(line, numbered[match[1]][n]...)
+	}
+
+	lines := yamlLines(settings)
+	for _, list := range []string{"targets", "webhooks"} {
+		items := numbered[listItemNames[list]]
+		if len(items) == 0 {
+			continue
+		}
+		lines = append(lines, "", list+":")
+		lines = append(lines, yamlList(items)...)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// yamlList returns the numbered items as a YAML list
+func yamlList(items map[int][]property) []string {
+	lines := []string{}
+	for _, n := range sortedKeys(items) {
+		for i, line := range yamlLines(items[n]) {
+			if i == 0 {
+				lines = append(lines, "  - "+line)
+			} else {
+				lines = append(lines, "    "+line)
+			}
+		}
+	}
+	return lines
+}
+
+// yamlLines returns the properties as YAML lines, in the order they first appear.
+// Numbered assertions and steps become lists, and names with a dot become a map, e.g. header.Accept
+func yamlLines(props []property) []string {
+	order := []string{}
+	values := map[string]string{}
+	maps := map[string][]property{}
+	assertions := map[int]string{}
+	steps := map[int][]property{}
+	for _, prop := range props {
+		key := prop.name
+		if match := assertNameRegex.FindStringSubmatch(prop.name); match != nil {
+			key = "assertions"
+			n, _ := strconv.Atoi(match[1])
+			assertions[n] = prop.value
+		} else if match := stepNameRegex.FindStringSubmatch(prop.name); match != nil {
+			key = "steps"
+			n, _ := strconv.Atoi(match[1])
+			steps[n] = append(steps[n], property{name: match[2], value: prop.value})
+		} else if i := strings.Index(prop.name, "."); i > 0 {
+			key = prop.name[:i]
+			maps[key] = append(maps[key], property{name: prop.name[i+1:], value: prop.value})
+		} else {
+			values[key] = prop.value
+		}
+		if !containsString(order, key) {
+			order = append(order, key)
+		}
+	}
+
+	lines := []string{}
+	for _, key := range order {
+		if value, ok := values[key]; ok {
+			lines = append(lines, yamlQuote(key)+": "+yamlQuote(value))
+		} else if key == "assertions" {
+			lines = append(lines, "assertions:")
+			numbers := []int{}
+			for n := range assertions {
+				numbers = append(numbers, n)
+			}
+			sort.Ints(numbers)
+			for _, n := range numbers {
+				lines = append(lines, "  - "+yamlQuote(assertions[n]))
+			}
+		} else if key == "steps" {
+			lines = append(lines, "steps:")
+			lines = append(lines, yamlList(steps)...)
+		} else {
+			lines = append(lines, yamlQuote(key)+":")
+			for _, line := range yamlLines(maps[key]) {
+				lines = append(lines, "  "+line)
+			}
+		}
+	}
+	return lines
+}
+
+// yamlQuote returns the value as a YAML scalar, quoted unless it reads back as the same string
+func yamlQuote(value string) string {
+	plain := len(value) > 0 &&
+		value == strings.TrimSpace(value) &&
+		!strings.ContainsAny(value, "#'\"\\\n\t") &&
+		!strings.Contains(value, ": ") &&
+		!strings.HasSuffix(value, ":") &&
+		!strings.ContainsAny(value[:1], "-?:,[]{}&*!|>%@`")
+	if plain && !plainIntegerRegex.MatchString(value) {
+		// YAML reads numbers, bools, and nulls as something other than a string
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			plain = false
+		} else if _, err := strconv.ParseInt(value, 0, 64); err == nil {
+			plain = false
+		}
+		switch strings.ToLower(value) {
+		case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~":
+			plain = value == "true" || value == "false"
+		}
+	}
+	if plain {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+func sortedKeys(items map[int][]property) []int {
+	keys := []int{}
+	for n := range items {
+		keys = append(keys, n)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_flattenYAML(t *testing.T) {
+	// As yaml.Unmarshal reads a YAML config file
+	doc := map[interface{}]interface{}{
+		"maxResponseTimeInSeconds": 30,
+		"mailTo":                   []interface{}{"a@example.com", "b@example.com"},
+		"targets": []interface{}{
+			map[interface{}]interface{}{
+				"host":       "google",
+				"url":        "https://www.google.com",
+				"password":   "a password, with a comma",
+				"assertions": []interface{}{"contains Google", "notContains Error"},
+			},
+		},
+		"webhooks": []interface{}{
+			map[interface{}]interface{}{
+				"url":    "https://chat.example.com/hooks/abc",
+				"header": map[interface{}]interface{}{"Authorization": "Bearer abc"},
+			},
+		},
+	}
+	props := map[string]string{}
+	if err := flattenYAML("", doc, props); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"maxResponseTimeInSeconds":      "30",
+		"mailTo":                        "a@example.com, b@example.com",
+		"monitor.target1.host":          "google",
+		"monitor.target1.url":           "https://www.google.com",
+		"monitor.target1.password":      "a password, with a comma",
+		"monitor.target1.assert1":       "contains Google",
+		"monitor.target1.assert2":       "notContains Error",
+		"webhook1.url":                  "https://chat.example.com/hooks/abc",
+		"webhook1.header.Authorization": "Bearer abc",
+	}
+	if len(props) != len(expected) {
+		t.Errorf("expected %d properties, got %d: %v", len(expected), len(props), props)
+	}
+	for name, value := range expected {
+		if props[name] != value {
+			t.Errorf("expected %s to be %q, got %q", name, value, props[name])
+		}
+	}
+
+	defer currentSettings().restore()
+	_processConfig(props)
+	if len(targets) != 1 || targets[0].password != "a password, with a comma" || len(targets[0].assertions) != 2 {
+		t.Errorf("expected the target of the YAML config, got %+v", targets)
+	}
+}
+
+func Test_convertConfig(t *testing.T) {
+	props := []property{
+		{"maxResponseTimeInSeconds", "30"},
+		{"monitor.target1", "google, https://www.google.com"},
+		{"monitor.target1.assert2", "notContains Error"},
+		{"monitor.target1.assert1", "contains Google"},
+		{"monitor.target1.expectedStatus", "200-299, 304"},
+		{"monitor.target2", "internal, https://internal.example.com, joe, 0123"},
+		{"shellCommand", "dump: threads"},
+		{"webhook1.url", "https://chat.example.com/hooks/abc"},
+		{"webhook1.header.Authorization", "Bearer abc"},
+	}
+	expected := `maxResponseTimeInSeconds: 30
+shellCommand: "dump: threads"
+
+targets:
+  - host: google
+    url: https://www.google.com
+    assertions:
+      - contains Google
+      - notContains Error
+    expectedStatus: 200-299, 304
+  - host: internal
+    url: https://internal.example.com
+    user: joe
+    password: "0123"
+
+webhooks:
+  - url: https://chat.example.com/hooks/abc
+    header:
+      Authorization: Bearer abc
+`
+	if converted := convertConfig(props); converted != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, converted)
+	}
+}